@@ -1,16 +1,16 @@
 package main
 
 import (
-	"archive/tar"
 	"bufio"
-	"bytes"
-	"compress/gzip"
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
-	"io"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"text/tabwriter"
 
 	"github.com/bpva/gopm/pkg/archiver"
 	"github.com/bpva/gopm/pkg/config"
@@ -22,8 +22,17 @@ func main() {
 	flag.Usage = func() {
 		fmt.Fprintf(os.Stderr, "Usage of %s:\n", os.Args[0])
 		fmt.Fprintf(os.Stderr, "Commands:\n")
-		fmt.Fprintf(os.Stderr, "  create  Create a package\n")
-		fmt.Fprintf(os.Stderr, "  update  Update packages\n")
+		fmt.Fprintf(os.Stderr, "  init      Write a new package file\n")
+		fmt.Fprintf(os.Stderr, "  create    Create a package and upload it\n")
+		fmt.Fprintf(os.Stderr, "  pack      Create a package archive without uploading it\n")
+		fmt.Fprintf(os.Stderr, "  publish   Upload a package archive created by pack\n")
+		fmt.Fprintf(os.Stderr, "  update    Update packages\n")
+		fmt.Fprintf(os.Stderr, "  why       Explain why a package is installed\n")
+		fmt.Fprintf(os.Stderr, "  outdated  List packages with newer versions on the server\n")
+		fmt.Fprintf(os.Stderr, "  upgrade   Upgrade selected packages keeping the rest of the lock file\n")
+		fmt.Fprintf(os.Stderr, "  info      Show the metadata of a package on the server\n")
+		fmt.Fprintf(os.Stderr, "  list      List the packages on the server\n")
+		fmt.Fprintf(os.Stderr, "  schema    Print the JSON Schema of package or update files\n")
 		fmt.Fprintf(os.Stderr, "Flags:\n")
 		fmt.Fprintf(os.Stderr, "  -env  Path to the .env file\n")
 	}
@@ -35,6 +44,52 @@ func main() {
 		os.Exit(1)
 	}
 
+	command := flag.Arg(0)
+
+	// Commands that do not connect to the server
+	switch command {
+	case "schema":
+		schemaFlags := flag.NewFlagSet("schema", flag.ExitOnError)
+		outDir := schemaFlags.String("out", "", "Write package.schema.json and update.schema.json to this directory")
+		schemaFlags.Parse(flag.Args()[1:])
+		if schemaFlags.NArg() < 1 && *outDir == "" {
+			fmt.Fprintf(os.Stderr, "Usage: %s schema package|update\n       %s schema -out <dir>\n", os.Args[0], os.Args[0])
+			os.Exit(1)
+		}
+		printSchema(schemaFlags.Arg(0), *outDir)
+		return
+	case "pack":
+		packFlags := flag.NewFlagSet("pack", flag.ExitOnError)
+		build := addBuildFlags(packFlags)
+		output := packFlags.String("o", "", "Path of the archive, name-version.gopm by default")
+		packFlags.Parse(flag.Args()[1:])
+		if packFlags.NArg() < 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s pack [-o archive] [-overwrite] [-verbose] [-dereference] [-format zip|tar.gz|tar.zst] [-level n] [-reproducible] [-sign key] [-set key=value]... <package.json>\n", os.Args[0])
+			os.Exit(1)
+		}
+		pack(packFlags.Arg(0), build, *output)
+		return
+	case "init":
+		initFlags := flag.NewFlagSet("init", flag.ExitOnError)
+		name := initFlags.String("name", "", "Package name, the name of the current directory by default")
+		version := initFlags.String("ver", "0.1.0", "Package version")
+		targets := initFlags.String("target", "", "Comma-separated targets, detected in the current directory by default")
+		dependencies := initFlags.String("dep", "", "Comma-separated dependencies such as packet-2>=1.5")
+		yes := initFlags.Bool("yes", false, "Do not ask, use the flags and defaults")
+		force := initFlags.Bool("force", false, "Overwrite an existing package file")
+		initFlags.Parse(flag.Args()[1:])
+		if initFlags.NArg() > 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s init [-name name] [-ver version] [-target path,...] [-dep name>=version,...] [-yes] [-force] [packet.json|packet.yaml]\n", os.Args[0])
+			os.Exit(1)
+		}
+		packageFile := initFlags.Arg(0)
+		if packageFile == "" {
+			packageFile = "packet.json"
+		}
+		initPackage(packageFile, initAnswers{name: *name, version: *version, targets: *targets, dependencies: *dependencies}, !*yes, *force)
+		return
+	}
+
 	envFilePath := flag.String("env", "", "Path to the .env file")
 	sshConfig, err := config.Configure(*envFilePath)
 	if err != nil {
@@ -42,20 +97,83 @@ func main() {
 		os.Exit(1)
 	}
 
-	command := flag.Arg(0)
 	switch command {
 	case "create":
-		if flag.NArg() < 2 {
-			fmt.Fprintf(os.Stderr, "Usage: %s create <package.json>\n", os.Args[0])
+		createFlags := flag.NewFlagSet("create", flag.ExitOnError)
+		build := addBuildFlags(createFlags)
+		force := createFlags.Bool("force", false, "Overwrite the version on the remote server if it has other contents")
+		createFlags.Parse(flag.Args()[1:])
+		if createFlags.NArg() < 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s create [-force] [-overwrite] [-verbose] [-dereference] [-format zip|tar.gz|tar.zst] [-level n] [-reproducible] [-sign key] [-set key=value]... <package.json>\n", os.Args[0])
+			os.Exit(1)
+		}
+		create(createFlags.Arg(0), build, *force, sshConfig)
+	case "publish":
+		publishFlags := flag.NewFlagSet("publish", flag.ExitOnError)
+		force := publishFlags.Bool("force", false, "Overwrite the version on the remote server if it has other contents")
+		publishFlags.Parse(flag.Args()[1:])
+		if publishFlags.NArg() < 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s publish [-force] <archive>\n", os.Args[0])
 			os.Exit(1)
 		}
-		create(flag.Arg(1), sshConfig)
+		publish(publishFlags.Arg(0), *force, sshConfig)
 	case "update":
-		if flag.NArg() < 2 {
-			fmt.Fprintf(os.Stderr, "Usage: %s create <package.json>\n", os.Args[0])
+		updateFlags := flag.NewFlagSet("update", flag.ExitOnError)
+		options := addResolveFlags(updateFlags)
+		install := addInstallFlags(updateFlags)
+		updateFlags.Parse(flag.Args()[1:])
+		if updateFlags.NArg() < 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s update [-pre] [-without dev,optional] [-label label,...] [-set key=value]... [-fsync] [-allow-unsigned] <packages.json>\n", os.Args[0])
+			os.Exit(1)
+		}
+		install.configureTrust()
+		update(updateFlags.Arg(0), options, install, sshConfig)
+	case "why":
+		whyFlags := flag.NewFlagSet("why", flag.ExitOnError)
+		options := addResolveFlags(whyFlags)
+		whyFlags.Parse(flag.Args()[1:])
+		if whyFlags.NArg() < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: %s why [-pre] [-without dev,optional] [-label label,...] [-set key=value]... <packages.json> <package>\n", os.Args[0])
+			os.Exit(1)
+		}
+		why(whyFlags.Arg(0), whyFlags.Arg(1), options, sshConfig)
+	case "outdated":
+		outdatedFlags := flag.NewFlagSet("outdated", flag.ExitOnError)
+		jsonOutput := outdatedFlags.Bool("json", false, "Print the report as JSON")
+		options := addResolveFlags(outdatedFlags)
+		outdatedFlags.Parse(flag.Args()[1:])
+		if outdatedFlags.NArg() < 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s outdated [-json] [-pre] [-without dev,optional] [-label label,...] [-set key=value]... <packages.json>\n", os.Args[0])
 			os.Exit(1)
 		}
-		update(flag.Arg(1), sshConfig)
+		outdated(outdatedFlags.Arg(0), *jsonOutput, options, sshConfig)
+	case "upgrade":
+		upgradeFlags := flag.NewFlagSet("upgrade", flag.ExitOnError)
+		recursive := upgradeFlags.Bool("recursive", false, "Also upgrade the dependencies of the named packages")
+		options := addResolveFlags(upgradeFlags)
+		install := addInstallFlags(upgradeFlags)
+		upgradeFlags.Parse(flag.Args()[1:])
+		if upgradeFlags.NArg() < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: %s upgrade [-recursive] [-pre] [-without dev,optional] [-label label,...] [-set key=value]... [-fsync] [-allow-unsigned] <packages.json> <package>...\n", os.Args[0])
+			os.Exit(1)
+		}
+		install.configureTrust()
+		upgrade(upgradeFlags.Arg(0), upgradeFlags.Args()[1:], *recursive, options, install, sshConfig)
+	case "info":
+		infoFlags := flag.NewFlagSet("info", flag.ExitOnError)
+		jsonOutput := infoFlags.Bool("json", false, "Print the metadata as JSON")
+		infoFlags.Parse(flag.Args()[1:])
+		if infoFlags.NArg() < 1 {
+			fmt.Fprintf(os.Stderr, "Usage: %s info [-json] <package> [version]\n", os.Args[0])
+			os.Exit(1)
+		}
+		info(infoFlags.Arg(0), infoFlags.Arg(1), *jsonOutput, sshConfig)
+	case "list":
+		listFlags := flag.NewFlagSet("list", flag.ExitOnError)
+		jsonOutput := listFlags.Bool("json", false, "Print the list as JSON")
+		labels := listFlags.String("label", "", "Comma-separated labels, list only packages with one of them")
+		listFlags.Parse(flag.Args()[1:])
+		list(splitList(*labels), *jsonOutput, sshConfig)
 	default:
 		fmt.Fprintln(os.Stderr, "Unknown command. Available commands:")
 		flag.Usage()
@@ -63,92 +181,322 @@ func main() {
 	}
 }
 
-func create(packageFile string, sshConfig config.SSHConfig) {
-	name, version, err := packager.GetNameAndVersionFromConfigFile(packageFile)
+// resolveOptions holds the command line flags that change how the packages
+// of an update file are resolved.
+type resolveOptions struct {
+	pre     bool
+	without string
+	labels  string
+	vars    setFlag
+}
+
+func addResolveFlags(fs *flag.FlagSet) *resolveOptions {
+	options := &resolveOptions{vars: setFlag{}}
+	fs.BoolVar(&options.pre, "pre", false, "Allow pre-release versions")
+	fs.StringVar(&options.without, "without", "", "Comma-separated dependency groups to leave out (dev, optional)")
+	fs.StringVar(&options.labels, "label", "", "Comma-separated labels selecting label-restricted dependencies")
+	fs.Var(options.vars, "set", "Set a variable of the update file as key=value, may be repeated")
+	return options
+}
+
+func (o *resolveOptions) apply(updateConfig *packager.UpdateConfig) {
+	updateConfig.AllowPrerelease = updateConfig.AllowPrerelease || o.pre
+	updateConfig.Without = append(updateConfig.Without, splitList(o.without)...)
+	updateConfig.Labels = append(updateConfig.Labels, splitList(o.labels)...)
+}
+
+// installOptions holds the command line flags and the trust configuration
+// that apply when downloaded packages are installed.
+type installOptions struct {
+	sync          bool
+	allowUnsigned bool
+	trust         config.TrustConfig
+}
+
+func addInstallFlags(fs *flag.FlagSet) *installOptions {
+	options := &installOptions{}
+	fs.BoolVar(&options.sync, "fsync", false, "Flush every extracted file to disk")
+	fs.BoolVar(&options.allowUnsigned, "allow-unsigned", false, "Install unsigned and untrusted packages with a warning")
+	return options
+}
+
+// configureTrust reads the keyring named by GOPM_TRUSTED_KEYS. Only the
+// commands installing packages read it, so a broken keyring does not stop
+// the others.
+func (o *installOptions) configureTrust() {
+	trust, err := config.ConfigureTrust()
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to get name and version from config file: %s\n", err)
+		fmt.Fprintf(os.Stderr, "failed to configure trusted keys: %v\n", err)
 		os.Exit(1)
 	}
-	supposedPath := fmt.Sprintf("gopm_packages/%s/%s", name, version)
-	// Check if the package directory already exists
-	if _, err := os.Stat(supposedPath); err == nil {
-		fmt.Printf("Directory '%s' already exists. Do you want to force rewrite it? (yes/no): ", supposedPath)
-		reader := bufio.NewReader(os.Stdin)
-		answer, _ := reader.ReadString('\n')
-		answer = strings.ToLower(strings.TrimSpace(answer))
-		if answer != "yes" && answer != "y" {
-			fmt.Println("Skipping package upload and unpack...")
-			return
+	o.trust = trust
+}
+
+// verify checks the checksums of an unpacked package and, when a keyring is
+// configured, its signature.
+func (o *installOptions) verify(packageDir string) error {
+	err := packager.VerifyChecksums(packageDir)
+	if errors.Is(err, packager.ErrNoChecksums) {
+		if o.trust.RequireSignatures() && !o.allowUnsigned {
+			return packager.ErrUnsigned
 		}
+		fmt.Fprintf(os.Stderr, "warning: %s was published without checksums and is not verified\n", packageDir)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if !o.trust.RequireSignatures() {
+		return nil
+	}
+	err = packager.VerifySignature(packageDir, o.trust.TrustedKeys)
+	if o.allowUnsigned && (errors.Is(err, packager.ErrUnsigned) || errors.Is(err, packager.ErrUntrusted)) {
+		fmt.Fprintf(os.Stderr, "warning: %s: %s\n", packageDir, err)
+		return nil
 	}
+	return err
+}
 
-	if _, err := os.Stat(supposedPath); err == nil {
-		// Delete the package directory
-		err = os.RemoveAll(supposedPath)
+// setFlag collects repeated -set key=value flags.
+type setFlag map[string]string
+
+func (s setFlag) String() string {
+	pairs := []string{}
+	for key, value := range s {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (s setFlag) Set(pair string) error {
+	key, value, ok := strings.Cut(pair, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("expected key=value, got %q", pair)
+	}
+	s[key] = value
+	return nil
+}
+
+func splitList(list string) []string {
+	values := []string{}
+	for _, value := range strings.Split(list, ",") {
+		if value = strings.TrimSpace(value); value != "" {
+			values = append(values, value)
+		}
+	}
+	return values
+}
+
+// buildOptions holds the command line flags of create and pack.
+type buildOptions struct {
+	verbose      *bool
+	dereference  *bool
+	format       *string
+	level        *int
+	reproducible *bool
+	signingKey   *string
+	overwrite    *bool
+	vars         setFlag
+}
+
+func addBuildFlags(fs *flag.FlagSet) *buildOptions {
+	o := &buildOptions{vars: setFlag{}}
+	o.verbose = fs.Bool("verbose", false, "List the files left out of the package")
+	o.dereference = fs.Bool("dereference", false, "Copy the files symlinks point to instead of the links")
+	o.format = fs.String("format", string(archiver.DefaultFormat), "Archive format: zip, tar.gz or tar.zst")
+	o.level = fs.Int("level", 0, "Compression level, 0 for the default of the format")
+	o.reproducible = fs.Bool("reproducible", false, "Build the same archive bytes from the same files")
+	o.signingKey = fs.String("sign", "", "Path to the Ed25519 private key signing the package")
+	o.overwrite = fs.Bool("overwrite", false, "Replace the package directory in gopm_packages if it exists")
+	fs.Var(o.vars, "set", "Set a variable of the package file as key=value, may be repeated")
+	return o
+}
+
+func (o *buildOptions) options() (packager.CreateOptions, archiver.Options, error) {
+	archiveFormat, err := archiver.ParseFormat(*o.format)
+	if err != nil {
+		return packager.CreateOptions{}, archiver.Options{}, err
+	}
+	options := packager.CreateOptions{Verbose: *o.verbose, Dereference: *o.dereference, Format: archiveFormat, Vars: o.vars, Overwrite: *o.overwrite}
+	if *o.signingKey != "" {
+		options.SigningKey, err = config.LoadSigningKey(*o.signingKey)
 		if err != nil {
-			fmt.Fprintf(os.Stderr, "failed to delete package directory: %s\n", err)
-			os.Exit(1)
+			return packager.CreateOptions{}, archiver.Options{}, err
 		}
 	}
+	return options, archiver.Options{Format: archiveFormat, Level: *o.level, Reproducible: *o.reproducible}, nil
+}
+
+// buildPackage creates the package directory of packageFile in gopm_packages
+// and archives it.
+func buildPackage(packageFile string, build *buildOptions) (name, version string, arch []byte) {
+	options, archiveOptions, err := build.options()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	name, version, err = packager.GetNameAndVersionFromConfigFile(packageFile, options.Vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get name and version from config file: %s\n", err)
+		os.Exit(1)
+	}
 
-	packageDir, err := packager.CreatePackage(packageFile)
+	packageDir, err := packager.CreatePackage(packageFile, options)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create package: %s\n", err)
 		os.Exit(1)
 	}
 
-	arch, err := archiver.Archive(packageDir)
+	arch, err = archiver.ArchiveWithOptions(packageDir, archiveOptions)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create archive: %s\n", err)
 		os.Exit(1)
 	}
-	err = connector.CheckSSHConnection(sshConfig)
+	fmt.Printf("Package %s v%s created localy\n", name, version)
+	return name, version, arch
+}
+
+func create(packageFile string, build *buildOptions, force bool, sshConfig config.SSHConfig) {
+	name, version, arch := buildPackage(packageFile, build)
+	upload(arch, name, version, force, sshConfig)
+}
+
+// pack creates the package archive of packageFile without uploading it.
+func pack(packageFile string, build *buildOptions, output string) {
+	name, version, arch := buildPackage(packageFile, build)
+	if output == "" {
+		output = fmt.Sprintf("%s-%s.gopm", name, version)
+	}
+	err := os.WriteFile(output, arch, 0644)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write archive: %s\n", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Package %s v%s written to %s\n", name, version, output)
+}
+
+// publish uploads an archive created by pack. The name and version are
+// taken from metadata.json in the archive.
+func publish(archiveFile string, force bool, sshConfig config.SSHConfig) {
+	arch, err := os.ReadFile(archiveFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read archive: %s\n", err)
+		os.Exit(1)
+	}
+	metadata, err := packager.ReadArchiveMetadata(arch)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read package metadata from %s: %s\n", archiveFile, err)
+		os.Exit(1)
+	}
+	upload(arch, metadata.Name, metadata.Version, force, sshConfig)
+}
+
+// upload unpacks arch as version of package name on the remote server. An
+// existing version with other contents is only replaced when force is set;
+// one with the same contents is left as it is.
+func upload(arch []byte, name, version string, force bool, sshConfig config.SSHConfig) {
+	err := connector.CheckSSHConnection(sshConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to connect to SSH server: %s\n", err)
 		os.Exit(1)
 	} else {
 		fmt.Println("SSH connection successful")
 	}
-	fmt.Printf("Package %s v%s created localy\n", name, version)
 	sshClient, err := connector.CreateSSHClient(sshConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create SSH client: %s\n", err)
 		os.Exit(1)
 	}
 
-	err = connector.UploadAndUnpackArchive(arch, sshClient, name, version)
+	if !force {
+		needed, err := packager.CheckPublish(arch, name, version, sshClient)
+		if errors.Is(err, packager.ErrVersionExists) {
+			fmt.Fprintf(os.Stderr, "%s\nuse -force to overwrite it\n", err)
+			os.Exit(1)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to check the remote version: %s\n", err)
+			os.Exit(1)
+		}
+		if !needed {
+			fmt.Printf("Package %s v%s is already published with the same contents\n", name, version)
+			return
+		}
+	}
+
+	err = connector.UploadAndUnpackArchive(arch, sshClient, name, version, force)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to upload and unpack archive: %s\n", err)
 		os.Exit(1)
 	} else {
 		fmt.Printf("Package %s v%s uploaded and unpacked on remote server %s@%s\n", name, version, sshConfig.Login, sshConfig.Host)
 	}
-
 }
 
-func update(packageFile string, sshConfig config.SSHConfig) {
-	updateConfig, err := packager.ReadUpdateFile(packageFile)
+func update(packageFile string, options *resolveOptions, install *installOptions, sshConfig config.SSHConfig) {
+	updateConfig, err := packager.ReadUpdateFile(packageFile, options.vars)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to read update file: %v\n", err)
 		os.Exit(1)
 	}
+	options.apply(&updateConfig)
 
 	sshClient, err := connector.CreateSSHClient(sshConfig)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to create SSH client: %s\n", err)
 		os.Exit(1)
 	}
-	err = packager.CollectDependencies(&updateConfig, sshClient)
+	resolution, err := packager.ResolveVersions(updateConfig, sshClient)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to collect dependencies: %s\n", err)
+		fmt.Fprintf(os.Stderr, "failed to resolve dependencies: %s\n", err)
 		os.Exit(1)
 	}
+	warnResolution(resolution)
 
-	arch, versions, err := connector.DownloadUpdates(updateConfig, sshClient)
+	downloads, err := connector.DownloadPackages(resolution.Versions, sshClient)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "failed to download updates: %s\n", err)
 		os.Exit(1)
 	}
+	installPackages(downloads, resolution.Versions, install)
+
+	err = packager.WriteLockFile(packager.LockFilePath(packageFile), packager.LockFile{Packages: resolution.Versions})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write lock file: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// warnResolution reports the optional packages a resolution left out and the
+// version directories it ignored.
+func warnResolution(resolution packager.Resolution) {
+	for _, name := range resolution.Invalid {
+		fmt.Fprintf(os.Stderr, "warning: ignoring gopm_packages/%s: not a valid semantic version\n", name)
+	}
+	for _, name := range resolution.Skipped {
+		fmt.Fprintf(os.Stderr, "warning: skipping optional package %s: no suitable version available\n", name)
+	}
+}
+
+// installPackages checks the downloaded archive of every package version and
+// unpacks it into its directory in gopm_packages.
+func installPackages(downloads map[string]connector.Download, versions map[string]string, install *installOptions) {
+	// check the archives before any local version is touched
+	for packageName, version := range versions {
+		download := downloads[packageName]
+		if download.Legacy {
+			fmt.Fprintf(os.Stderr, "warning: %s v%s was published without its archive, which is not verified\n", packageName, version)
+			continue
+		}
+		err := packager.VerifyArchive(download.Archive, download.Checksum)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "refusing to install %s v%s: %s\n", packageName, version, err)
+			os.Exit(1)
+		}
+	}
+
 	// delete versions to update
 	fmt.Printf("Deleting local versions...\n")
 	for packageName, version := range versions {
@@ -161,55 +509,371 @@ func update(packageFile string, sshConfig config.SSHConfig) {
 	}
 	fmt.Printf("Local versions deleted\n")
 
-	// unpack arch
+	// unpack the archives
 	fmt.Printf("Unpacking...\n")
-	archReader := bytes.NewReader(arch)
-	err = ExtractTarGz(archReader, "gopm_packages")
+	for packageName, version := range versions {
+		packageDir := fmt.Sprintf("gopm_packages/%s/%s", packageName, version)
+		err := archiver.Extract(downloads[packageName].Archive, packageDir, archiver.ExtractOptions{Sync: install.sync})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to unpack archive of %s v%s: %s\n", packageName, version, err)
+			os.Exit(1)
+		}
+	}
+
+	// verify the unpacked files before they are used
+	for packageName, version := range versions {
+		packageDir := fmt.Sprintf("gopm_packages/%s/%s", packageName, version)
+		err := install.verify(packageDir)
+		if err != nil {
+			for packageName, version := range versions {
+				_ = os.RemoveAll(fmt.Sprintf("gopm_packages/%s/%s", packageName, version))
+			}
+			fmt.Fprintf(os.Stderr, "refusing to install %s v%s: %s\n", packageName, version, err)
+			os.Exit(1)
+		}
+	}
+	fmt.Printf("Archive unpacked. Local versions updated\n")
+}
+
+func upgrade(packageFile string, packages []string, recursive bool, options *resolveOptions, install *installOptions, sshConfig config.SSHConfig) {
+	updateConfig, err := packager.ReadUpdateFile(packageFile, options.vars)
 	if err != nil {
-		fmt.Fprintf(os.Stderr, "failed to unpack archive: %s\n", err)
+		fmt.Fprintf(os.Stderr, "failed to read update file: %v\n", err)
 		os.Exit(1)
 	}
-	fmt.Printf("Archive unpacked. Local versions updated\n")
+	options.apply(&updateConfig)
+
+	lockFile := packager.LockFilePath(packageFile)
+	lock, err := packager.ReadLockFile(lockFile)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%s. Run update first to create it\n", err)
+		os.Exit(1)
+	}
+
+	sshClient, err := connector.CreateSSHClient(sshConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create SSH client: %s\n", err)
+		os.Exit(1)
+	}
+	defer sshClient.Close()
+
+	resolution, err := packager.ResolveUpgrade(updateConfig, lock, packages, recursive, sshClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to resolve dependencies: %s\n", err)
+		os.Exit(1)
+	}
+	warnResolution(resolution)
 
+	changes := packager.DiffLock(lock.Packages, resolution.Versions)
+	if len(changes) == 0 {
+		fmt.Println("All packages are up to date")
+		return
+	}
+	changed := map[string]string{}
+	for _, change := range changes {
+		switch {
+		case change.Old == "":
+			fmt.Printf("+ %s %s\n", change.Name, change.New)
+		case change.New == "":
+			fmt.Printf("- %s %s\n", change.Name, change.Old)
+		default:
+			fmt.Printf("~ %s %s -> %s\n", change.Name, change.Old, change.New)
+		}
+		if change.New != "" {
+			changed[change.Name] = change.New
+		}
+	}
+
+	if len(changed) > 0 {
+		downloads, err := connector.DownloadPackages(changed, sshClient)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to download updates: %s\n", err)
+			os.Exit(1)
+		}
+		installPackages(downloads, changed, install)
+	}
+
+	err = packager.WriteLockFile(lockFile, packager.LockFile{Packages: resolution.Versions})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to write lock file: %s\n", err)
+		os.Exit(1)
+	}
 }
 
-func ExtractTarGz(gzipStream io.Reader, destination string) error {
-	uncompressedStream, err := gzip.NewReader(gzipStream)
+func why(packageFile, packageName string, options *resolveOptions, sshConfig config.SSHConfig) {
+	updateConfig, err := packager.ReadUpdateFile(packageFile, options.vars)
 	if err != nil {
-		return err
+		fmt.Fprintf(os.Stderr, "failed to read update file: %v\n", err)
+		os.Exit(1)
+	}
+	options.apply(&updateConfig)
+
+	sshClient, err := connector.CreateSSHClient(sshConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create SSH client: %s\n", err)
+		os.Exit(1)
+	}
+	defer sshClient.Close()
+
+	paths, err := packager.ExplainDependency(updateConfig, packageName, sshClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to resolve dependencies: %s\n", err)
+		os.Exit(1)
+	}
+
+	if len(paths) == 0 {
+		fmt.Printf("Package %s is not required by %s\n", packageName, packageFile)
+		return
+	}
+	fmt.Printf("Package %s is required by %s through:\n", packageName, packageFile)
+	for _, path := range paths {
+		fmt.Printf("  %s\n", path)
+	}
+}
+
+func outdated(packageFile string, jsonOutput bool, options *resolveOptions, sshConfig config.SSHConfig) {
+	updateConfig, err := packager.ReadUpdateFile(packageFile, options.vars)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read update file: %v\n", err)
+		os.Exit(1)
 	}
-	defer uncompressedStream.Close()
+	options.apply(&updateConfig)
 
-	tarReader := tar.NewReader(uncompressedStream)
+	sshClient, err := connector.CreateSSHClient(sshConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create SSH client: %s\n", err)
+		os.Exit(1)
+	}
+	defer sshClient.Close()
 
-	for {
-		header, err := tarReader.Next()
-		if err == io.EOF {
-			break
+	packages, err := packager.FindOutdated(updateConfig, sshClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to check for outdated packages: %s\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		report, err := json.MarshalIndent(packages, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to marshal report to JSON: %s\n", err)
+			os.Exit(1)
 		}
+		fmt.Println(string(report))
+		return
+	}
+
+	if len(packages) == 0 {
+		fmt.Println("All packages are up to date")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tCONSTRAINT\tCURRENT\tWANTED\tLATEST")
+	for _, pkg := range packages {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pkg.Name, orDash(pkg.Constraint), orDash(pkg.Current), orDash(pkg.Wanted), pkg.Latest)
+	}
+	w.Flush()
+}
+
+func info(packageName, version string, jsonOutput bool, sshConfig config.SSHConfig) {
+	sshClient, err := connector.CreateSSHClient(sshConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create SSH client: %s\n", err)
+		os.Exit(1)
+	}
+	defer sshClient.Close()
+
+	metadata, err := packager.FetchMetadata(packageName, version, sshClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to read package metadata: %s\n", err)
+		os.Exit(1)
+	}
+
+	if jsonOutput {
+		report, err := json.MarshalIndent(metadata, "", "  ")
 		if err != nil {
-			return err
+			fmt.Fprintf(os.Stderr, "failed to marshal metadata to JSON: %s\n", err)
+			os.Exit(1)
 		}
+		fmt.Println(string(report))
+		return
+	}
 
-		target := filepath.Join(destination, header.Name)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Name:\t%s\n", metadata.Name)
+	fmt.Fprintf(w, "Version:\t%s\n", metadata.Version)
+	fmt.Fprintf(w, "Description:\t%s\n", orDash(metadata.Description))
+	fmt.Fprintf(w, "Authors:\t%s\n", orDash(strings.Join(metadata.Authors, ", ")))
+	fmt.Fprintf(w, "License:\t%s\n", orDash(metadata.License))
+	fmt.Fprintf(w, "Homepage:\t%s\n", orDash(metadata.Homepage))
+	fmt.Fprintf(w, "Repository:\t%s\n", orDash(metadata.Repository))
+	fmt.Fprintf(w, "Labels:\t%s\n", orDash(strings.Join(metadata.Labels, ", ")))
+	fmt.Fprintf(w, "Format:\t%s\n", orDash(string(metadata.Format)))
+	w.Flush()
+}
 
-		switch header.Typeflag {
-		case tar.TypeDir:
-			if err := os.MkdirAll(target, 0755); err != nil {
-				return err
-			}
-		case tar.TypeReg:
-			outFile, err := os.Create(target)
-			if err != nil {
-				return err
-			}
-			defer outFile.Close()
+func list(labels []string, jsonOutput bool, sshConfig config.SSHConfig) {
+	sshClient, err := connector.CreateSSHClient(sshConfig)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create SSH client: %s\n", err)
+		os.Exit(1)
+	}
+	defer sshClient.Close()
 
-			if _, err := io.Copy(outFile, tarReader); err != nil {
-				return err
-			}
+	packages, skipped, err := packager.ListPackages(labels, sshClient)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to list packages: %s\n", err)
+		os.Exit(1)
+	}
+	for _, err := range skipped {
+		fmt.Fprintf(os.Stderr, "warning: skipping package: %s\n", err)
+	}
+
+	if jsonOutput {
+		report, err := json.MarshalIndent(packages, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to marshal list to JSON: %s\n", err)
+			os.Exit(1)
 		}
+		fmt.Println(string(report))
+		return
 	}
 
-	return nil
+	if len(packages) == 0 {
+		fmt.Println("No packages found")
+		return
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "PACKAGE\tLATEST\tLICENSE\tLABELS\tDESCRIPTION")
+	for _, pkg := range packages {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", pkg.Name, pkg.Version, orDash(pkg.License), orDash(strings.Join(pkg.Labels, ",")), orDash(pkg.Description))
+	}
+	w.Flush()
+}
+
+// schemas maps the names accepted by the schema command to their generators.
+var schemas = map[string]func() ([]byte, error){
+	"package": packager.PackageSchema,
+	"update":  packager.UpdateSchema,
+}
+
+func printSchema(name, outDir string) {
+	if outDir == "" {
+		generate, ok := schemas[name]
+		if !ok {
+			fmt.Fprintf(os.Stderr, "unknown schema %s, expected package or update\n", name)
+			os.Exit(1)
+		}
+		content, err := generate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to generate schema: %s\n", err)
+			os.Exit(1)
+		}
+		os.Stdout.Write(content)
+		return
+	}
+
+	err := os.MkdirAll(outDir, os.ModePerm)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to create schema directory: %s\n", err)
+		os.Exit(1)
+	}
+	for name, generate := range schemas {
+		content, err := generate()
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to generate %s schema: %s\n", name, err)
+			os.Exit(1)
+		}
+		err = os.WriteFile(filepath.Join(outDir, name+".schema.json"), content, 0644)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "failed to write %s schema: %s\n", name, err)
+			os.Exit(1)
+		}
+	}
+}
+
+// initAnswers are the answers to the questions of init, given as flags or
+// typed in.
+type initAnswers struct {
+	name         string
+	version      string
+	targets      string
+	dependencies string
+}
+
+func initPackage(packageFile string, answers initAnswers, interactive, force bool) {
+	if !force {
+		if _, err := os.Stat(packageFile); err == nil {
+			fmt.Fprintf(os.Stderr, "%s already exists, use -force to overwrite it\n", packageFile)
+			os.Exit(1)
+		}
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "failed to get the current directory: %s\n", err)
+		os.Exit(1)
+	}
+	if answers.name == "" {
+		answers.name = packager.SuggestName(dir)
+	}
+	if answers.targets == "" {
+		answers.targets = strings.Join(packager.DetectTargets(dir), ",")
+	}
+
+	if interactive {
+		reader := bufio.NewReader(os.Stdin)
+		answers.name = ask(reader, "Package name", answers.name)
+		answers.version = ask(reader, "Version", answers.version)
+		answers.targets = ask(reader, "Targets (comma-separated)", answers.targets)
+		answers.dependencies = ask(reader, "Dependencies (comma-separated, e.g. packet-2>=1.5)", answers.dependencies)
+	}
+
+	pkg := &packager.Package{Name: answers.name, Version: answers.version}
+	for _, target := range splitList(answers.targets) {
+		pkg.Targets = append(pkg.Targets, packager.Target{Path: target})
+	}
+	for _, spec := range splitList(answers.dependencies) {
+		dependency, err := packager.ParseDependency(spec)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		pkg.Dependencies = append(pkg.Dependencies, dependency)
+	}
+	if len(pkg.Targets) == 0 {
+		fmt.Fprintln(os.Stderr, "no targets found in the current directory, give them with -target")
+		os.Exit(1)
+	}
+
+	if err := packager.WriteManifest(packageFile, pkg, force); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Printf("Wrote %s\n", packageFile)
+}
+
+// ask prints a question with its default answer and reads the answer from
+// reader. An empty answer keeps the default.
+func ask(reader *bufio.Reader, question, defaultAnswer string) string {
+	if defaultAnswer != "" {
+		fmt.Printf("%s [%s]: ", question, defaultAnswer)
+	} else {
+		fmt.Printf("%s: ", question)
+	}
+	answer, err := reader.ReadString('\n')
+	if err != nil && answer == "" {
+		fmt.Println()
+		return defaultAnswer
+	}
+	if answer = strings.TrimSpace(answer); answer != "" {
+		return answer
+	}
+	return defaultAnswer
+}
+
+func orDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
@@ -3,8 +3,11 @@ module github.com/bpva/gopm
 go 1.20
 
 require (
+	github.com/BurntSushi/toml v1.3.2
 	github.com/Masterminds/semver/v3 v3.2.1
+	github.com/bmatcuk/doublestar/v4 v4.10.2
 	github.com/joho/godotenv v1.5.1
+	github.com/klauspost/compress v1.17.9
 	github.com/pkg/sftp v1.13.5
 	golang.org/x/crypto v0.11.0
 	gopkg.in/yaml.v2 v2.4.0
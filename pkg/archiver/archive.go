@@ -1,23 +1,54 @@
 package archiver
 
 import (
+	"archive/tar"
 	"archive/zip"
 	"bytes"
+	"compress/flate"
+	"compress/gzip"
 	"fmt"
 	"io"
 	"os"
 	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
 )
 
+// Archive packs sourceDir into a ZIP archive.
 func Archive(sourceDir string) ([]byte, error) {
-	// Create a new buffer to hold the ZIP archive
+	return ArchiveWithOptions(sourceDir, Options{Format: DefaultFormat})
+}
+
+// ArchiveWithOptions packs sourceDir into an archive of the given format.
+func ArchiveWithOptions(sourceDir string, options Options) ([]byte, error) {
+	if options.Format == "" {
+		options.Format = DefaultFormat
+	}
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	var modTime time.Time
+	if options.Reproducible {
+		var err error
+		modTime, err = options.reproducibleModTime()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Create a new buffer to hold the archive
 	buf := new(bytes.Buffer)
 
-	// Create a new ZIP writer
-	archive := zip.NewWriter(buf)
+	archive, err := newArchiveWriter(buf, options)
+	if err != nil {
+		return nil, err
+	}
 
-	// Walk through the source directory and add all files to the ZIP archive
-	err := filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
+	// Walk through the source directory and add all files to the archive.
+	// filepath.Walk visits the files in lexical order, so the order of the
+	// entries does not depend on the file system.
+	err = filepath.Walk(sourceDir, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return fmt.Errorf("failed to access file or directory: %v", err)
 		}
@@ -27,55 +58,203 @@ func Archive(sourceDir string) ([]byte, error) {
 		if err != nil {
 			return fmt.Errorf("failed to get relative path: %v", err)
 		}
+		if relPath == "." {
+			return nil
+		}
 
-		// Create a new ZIP file header using the relative path
-		header, err := zip.FileInfoHeader(info)
-		if err != nil {
-			return fmt.Errorf("failed to create ZIP file header: %v", err)
+		if options.Reproducible {
+			info = normalizedFileInfo{FileInfo: info, modTime: modTime}
 		}
-		header.Name = relPath
 
-		// Check if the file is a directory
-		if info.IsDir() {
-			header.Name += "/"
+		return archive.add(path, filepath.ToSlash(relPath), info)
+	})
+
+	if err != nil {
+		archive.Close()
+		return nil, fmt.Errorf("failed to walk through source directory: %v", err)
+	}
+
+	// Close the writer to finalize the archive
+	err = archive.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to close %s archive: %v", options.Format, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// archiveWriter adds files of the source directory to one archive format.
+type archiveWriter interface {
+	add(path, name string, info os.FileInfo) error
+	Close() error
+}
+
+func newArchiveWriter(w io.Writer, options Options) (archiveWriter, error) {
+	switch options.Format {
+	case FormatZip:
+		archive := zip.NewWriter(w)
+		if options.Level != 0 {
+			archive.RegisterCompressor(zip.Deflate, func(out io.Writer) (io.WriteCloser, error) {
+				return flate.NewWriter(out, options.Level)
+			})
 		}
+		return &zipWriter{archive: archive}, nil
+	case FormatTarGz:
+		level := options.Level
+		if level == 0 {
+			level = gzip.DefaultCompression
+		}
+		compressor, err := gzip.NewWriterLevel(w, level)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create gzip writer: %v", err)
+		}
+		return &tarWriter{archive: tar.NewWriter(compressor), compressor: compressor, reproducible: options.Reproducible}, nil
+	case FormatTarZst:
+		level := zstd.SpeedDefault
+		if options.Level != 0 {
+			level = zstd.EncoderLevelFromZstd(options.Level)
+		}
+		compressor, err := zstd.NewWriter(w, zstd.WithEncoderLevel(level), zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create zstd writer: %v", err)
+		}
+		return &tarWriter{archive: tar.NewWriter(compressor), compressor: compressor, reproducible: options.Reproducible}, nil
+	default:
+		return nil, fmt.Errorf("unsupported archive format: %s", options.Format)
+	}
+}
+
+type zipWriter struct {
+	archive *zip.Writer
+}
+
+func (z *zipWriter) add(path, name string, info os.FileInfo) error {
+	// Create a new ZIP file header using the relative path
+	header, err := zip.FileInfoHeader(info)
+	if err != nil {
+		return fmt.Errorf("failed to create ZIP file header: %v", err)
+	}
+	header.Name = name
+
+	// Check if the file is a directory
+	if info.IsDir() {
+		header.Name += "/"
+	} else {
+		header.Method = zip.Deflate
+	}
 
-		// Write the header to the ZIP archive
-		writer, err := archive.CreateHeader(header)
+	// Write the header to the ZIP archive
+	writer, err := z.archive.CreateHeader(header)
+	if err != nil {
+		return fmt.Errorf("failed to create ZIP archive entry: %v", err)
+	}
+
+	// A symlink is stored as a link with its target as the contents
+	if info.Mode()&os.ModeSymlink != 0 {
+		linkTarget, err := os.Readlink(path)
 		if err != nil {
-			return fmt.Errorf("failed to create ZIP archive entry: %v", err)
+			return fmt.Errorf("failed to read symlink: %v", err)
 		}
+		_, err = writer.Write([]byte(linkTarget))
+		if err != nil {
+			return fmt.Errorf("failed to write symlink to ZIP archive: %v", err)
+		}
+		return nil
+	}
+
+	// If the file is not a directory, open it and copy its contents to the ZIP archive
+	if !info.IsDir() {
+		return copyContents(writer, path)
+	}
+
+	return nil
+}
 
-		// If the file is not a directory, open it and copy its contents to the ZIP archive
-		if !info.IsDir() {
-			file, err := os.Open(path)
-			if err != nil {
-				return fmt.Errorf("failed to open file: %v", err)
-			}
-			defer file.Close()
-
-			_, err = io.Copy(writer, file)
-			if err != nil {
-				return fmt.Errorf("failed to write file contents to ZIP archive: %v", err)
-			}
+func (z *zipWriter) Close() error {
+	return z.archive.Close()
+}
+
+type tarWriter struct {
+	archive      *tar.Writer
+	compressor   io.WriteCloser
+	reproducible bool
+}
+
+func (t *tarWriter) add(path, name string, info os.FileInfo) error {
+	linkTarget := ""
+	if info.Mode()&os.ModeSymlink != 0 {
+		var err error
+		linkTarget, err = os.Readlink(path)
+		if err != nil {
+			return fmt.Errorf("failed to read symlink: %v", err)
 		}
+	}
 
-		return nil
-	})
+	header, err := tar.FileInfoHeader(info, linkTarget)
+	if err != nil {
+		return fmt.Errorf("failed to create tar header: %v", err)
+	}
+	header.Name = name
+	if info.IsDir() {
+		header.Name += "/"
+	}
+	if t.reproducible {
+		header.Uid, header.Gid = 0, 0
+		header.Uname, header.Gname = "", ""
+		header.AccessTime, header.ChangeTime = time.Time{}, time.Time{}
+	}
 
+	err = t.archive.WriteHeader(header)
 	if err != nil {
-		archive.Close()
-		return nil, fmt.Errorf("failed to walk through source directory: %v", err)
+		return fmt.Errorf("failed to write tar header: %v", err)
 	}
 
-	// Close the ZIP writer to finalize the archive
-	err = archive.Close()
+	if header.Typeflag == tar.TypeReg {
+		return copyContents(t.archive, path)
+	}
+
+	return nil
+}
+
+func (t *tarWriter) Close() error {
+	if err := t.archive.Close(); err != nil {
+		t.compressor.Close()
+		return err
+	}
+	return t.compressor.Close()
+}
+
+// normalizedFileInfo reports a fixed modification time and a normalized mode
+// for the entries of a reproducible archive.
+type normalizedFileInfo struct {
+	os.FileInfo
+	modTime time.Time
+}
+
+func (n normalizedFileInfo) Mode() os.FileMode {
+	return normalizeMode(n.FileInfo.Mode())
+}
+
+func (n normalizedFileInfo) ModTime() time.Time {
+	return n.modTime
+}
+
+// Sys hides the owners and other system specific details of the file.
+func (n normalizedFileInfo) Sys() interface{} {
+	return nil
+}
+
+func copyContents(w io.Writer, path string) error {
+	file, err := os.Open(path)
 	if err != nil {
-		return nil, fmt.Errorf("failed to close ZIP archive: %v", err)
+		return fmt.Errorf("failed to open file: %v", err)
 	}
+	defer file.Close()
 
-	// Get the bytes of the ZIP archive from the buffer
-	zipBytes := buf.Bytes()
+	_, err = io.Copy(w, file)
+	if err != nil {
+		return fmt.Errorf("failed to write file contents to archive: %v", err)
+	}
 
-	return zipBytes, nil
+	return nil
 }
@@ -0,0 +1,78 @@
+package archiver
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeTree creates the same files, modes and symlink in a new directory,
+// with every modification time set to modTime.
+func writeTree(t *testing.T, modTime time.Time) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]os.FileMode{
+		"bin/tool":      0700,
+		"share/doc.txt": 0640,
+		"README.md":     0600,
+	}
+	for name, mode := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("doc.txt", filepath.Join(dir, "share", "link")); err != nil {
+		t.Fatal(err)
+	}
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.Mode()&os.ModeSymlink != 0 {
+			return err
+		}
+		return os.Chtimes(path, modTime, modTime)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestReproducibleArchives(t *testing.T) {
+	t.Setenv("SOURCE_DATE_EPOCH", "")
+	first := writeTree(t, time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	second := writeTree(t, time.Date(2023, 6, 15, 12, 30, 0, 0, time.UTC))
+
+	for _, format := range []Format{FormatZip, FormatTarGz, FormatTarZst} {
+		options := Options{Format: format, Reproducible: true}
+		a, err := ArchiveWithOptions(first, options)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		b, err := ArchiveWithOptions(second, options)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if !bytes.Equal(a, b) {
+			t.Errorf("%s: builds of the same files with other mtimes differ", format)
+		}
+
+		// Without reproducible mode the mtimes are kept and the bytes differ
+		options.Reproducible = false
+		a, err = ArchiveWithOptions(first, options)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		b, err = ArchiveWithOptions(second, options)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if bytes.Equal(a, b) {
+			t.Errorf("%s: builds with other mtimes are identical without reproducible mode", format)
+		}
+	}
+}
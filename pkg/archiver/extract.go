@@ -0,0 +1,450 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// ExtractOptions change how archives are extracted.
+type ExtractOptions struct {
+	// Sync flushes every extracted file and directory to disk.
+	Sync bool
+}
+
+type entryKind int
+
+const (
+	entryDir entryKind = iota
+	entryFile
+	entrySymlink
+	entryHardlink
+	entrySpecial
+)
+
+// entry is one file of an archive, independent of the archive format.
+type entry struct {
+	name     string
+	kind     entryKind
+	mode     os.FileMode
+	modTime  time.Time
+	linkname string
+	contents io.Reader
+}
+
+// Extract unpacks an archive of any supported format into destination,
+// keeping file modes, modification times and symlinks. Every entry is checked
+// with SafeJoin and CheckLink first, symlinks pointing through other symlinks
+// of the archive and device files are rejected with ErrUnsafeEntry.
+func Extract(arch []byte, destination string, options ExtractOptions) error {
+	format, err := DetectFormat(arch)
+	if err != nil {
+		return err
+	}
+
+	ex, err := newExtractor(destination, options)
+	if err != nil {
+		return err
+	}
+	err = readEntries(bytes.NewReader(arch), int64(len(arch)), format, ex.extract)
+	if err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+// ExtractTarGz unpacks a tar.gz stream into destination like Extract.
+func ExtractTarGz(gzipStream io.Reader, destination string, options ExtractOptions) error {
+	ex, err := newExtractor(destination, options)
+	if err != nil {
+		return err
+	}
+	err = readTar(gzipStream, FormatTarGz, ex.extract)
+	if err != nil {
+		return err
+	}
+	return ex.finish()
+}
+
+// Check verifies that every entry of an archive stays inside the directory it
+// is unpacked to, that no entry is unpacked through a symlink, that no symlink
+// points through another symlink and that the archive contains no device
+// files, without extracting anything.
+func Check(arch []byte) error {
+	format, err := DetectFormat(arch)
+	if err != nil {
+		return err
+	}
+
+	symlinks := linkSet{}
+	names := []string{}
+	err = readEntries(bytes.NewReader(arch), int64(len(arch)), format, func(e entry) error {
+		cleaned, err := checkName(e.name)
+		if err != nil {
+			return err
+		}
+		switch e.kind {
+		case entrySymlink:
+			if err := symlinks.add(e.name, e.linkname); err != nil {
+				return err
+			}
+		case entryHardlink:
+			if _, err := checkName(e.linkname); err != nil {
+				return err
+			}
+		case entrySpecial:
+			return fmt.Errorf("%w: %q is a device or special file", ErrUnsafeEntry, e.name)
+		}
+		names = append(names, cleaned)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// No entry may be unpacked through one of the symlinks
+	for _, name := range names {
+		parent := ""
+		for _, part := range parentDirs(name) {
+			parent = filepath.Join(parent, part)
+			if _, ok := symlinks[parent]; ok {
+				return fmt.Errorf("%w: path %q is inside the symlink %s", ErrUnsafeEntry, name, parent)
+			}
+		}
+	}
+
+	return nil
+}
+
+// maxReadFileSize limits the size of a file read by ReadFile.
+const maxReadFileSize = 16 << 20
+
+// ReadFile returns the contents of the regular file name in an archive of any
+// supported format without extracting the archive. It returns an error
+// wrapping os.ErrNotExist when the archive has no such file.
+func ReadFile(arch []byte, name string) ([]byte, error) {
+	format, err := DetectFormat(arch)
+	if err != nil {
+		return nil, err
+	}
+
+	var contents []byte
+	errFound := errors.New("found")
+	err = readEntries(bytes.NewReader(arch), int64(len(arch)), format, func(e entry) error {
+		if e.kind != entryFile || filepath.Clean(e.name) != filepath.Clean(name) {
+			return nil
+		}
+		data, err := io.ReadAll(io.LimitReader(e.contents, maxReadFileSize+1))
+		if err != nil {
+			return fmt.Errorf("failed to read %s in archive: %w", name, err)
+		}
+		if len(data) > maxReadFileSize {
+			return fmt.Errorf("%s in archive is larger than %d bytes", name, maxReadFileSize)
+		}
+		contents = data
+		return errFound
+	})
+	if err == errFound {
+		return contents, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("%s not found in archive: %w", name, os.ErrNotExist)
+}
+
+func readEntries(r io.ReaderAt, size int64, format Format, fn func(entry) error) error {
+	switch format {
+	case FormatZip:
+		return readZip(r, size, fn)
+	case FormatTarGz, FormatTarZst:
+		return readTar(io.NewSectionReader(r, 0, size), format, fn)
+	default:
+		return fmt.Errorf("unsupported archive format: %s", format)
+	}
+}
+
+func readTar(r io.Reader, format Format, fn func(entry) error) error {
+	var stream io.Reader
+	switch format {
+	case FormatTarGz:
+		uncompressedStream, err := gzip.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to open gzip stream: %w", err)
+		}
+		defer uncompressedStream.Close()
+		stream = uncompressedStream
+	case FormatTarZst:
+		uncompressedStream, err := zstd.NewReader(r)
+		if err != nil {
+			return fmt.Errorf("failed to open zstd stream: %w", err)
+		}
+		defer uncompressedStream.Close()
+		stream = uncompressedStream
+	default:
+		return fmt.Errorf("unsupported archive format: %s", format)
+	}
+
+	tarReader := tar.NewReader(stream)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read tar entry: %w", err)
+		}
+
+		e := entry{
+			name:     header.Name,
+			mode:     header.FileInfo().Mode(),
+			modTime:  header.ModTime,
+			linkname: header.Linkname,
+			contents: tarReader,
+		}
+		switch header.Typeflag {
+		case tar.TypeDir:
+			e.kind = entryDir
+		case tar.TypeReg:
+			e.kind = entryFile
+		case tar.TypeSymlink:
+			e.kind = entrySymlink
+		case tar.TypeLink:
+			e.kind = entryHardlink
+		case tar.TypeChar, tar.TypeBlock, tar.TypeFifo:
+			e.kind = entrySpecial
+		default:
+			// Extended headers and other metadata entries are skipped
+			continue
+		}
+
+		if err := fn(e); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readZip(r io.ReaderAt, size int64, fn func(entry) error) error {
+	reader, err := zip.NewReader(r, size)
+	if err != nil {
+		return fmt.Errorf("failed to read ZIP archive: %w", err)
+	}
+
+	for _, file := range reader.File {
+		mode := file.Mode()
+		e := entry{
+			name:    file.Name,
+			mode:    mode,
+			modTime: file.Modified,
+		}
+		switch {
+		case mode&(os.ModeDevice|os.ModeCharDevice|os.ModeNamedPipe|os.ModeSocket) != 0:
+			e.kind = entrySpecial
+		case mode.IsDir():
+			e.kind = entryDir
+		case mode&os.ModeSymlink != 0:
+			e.kind = entrySymlink
+			linkname, err := readZipFile(file, 4096)
+			if err != nil {
+				return err
+			}
+			e.linkname = string(linkname)
+		default:
+			e.kind = entryFile
+		}
+
+		if e.kind != entryFile {
+			if err := fn(e); err != nil {
+				return err
+			}
+			continue
+		}
+
+		contents, err := file.Open()
+		if err != nil {
+			return fmt.Errorf("failed to open %s in ZIP archive: %w", file.Name, err)
+		}
+		e.contents = contents
+		err = fn(e)
+		contents.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func readZipFile(file *zip.File, limit int64) ([]byte, error) {
+	contents, err := file.Open()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open %s in ZIP archive: %w", file.Name, err)
+	}
+	defer contents.Close()
+
+	data, err := io.ReadAll(io.LimitReader(contents, limit))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s in ZIP archive: %w", file.Name, err)
+	}
+	return data, nil
+}
+
+// extractor writes archive entries below destination.
+type extractor struct {
+	destination string
+	options     ExtractOptions
+	dirs        []entry
+	dirTargets  []string
+	symlinks    linkSet
+}
+
+func newExtractor(destination string, options ExtractOptions) (*extractor, error) {
+	err := os.MkdirAll(destination, 0755)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create destination directory: %w", err)
+	}
+	return &extractor{destination: destination, options: options, symlinks: linkSet{}}, nil
+}
+
+func (ex *extractor) extract(e entry) error {
+	target, err := SafeJoin(ex.destination, e.name)
+	if err != nil {
+		return err
+	}
+	if e.kind != entryDir {
+		if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+			return fmt.Errorf("failed to create directory for %s: %w", e.name, err)
+		}
+	}
+
+	switch e.kind {
+	case entryDir:
+		// The mode and mtime of the destination itself are not the archive's
+		// to change, a "./" entry is skipped
+		if target == filepath.Clean(ex.destination) {
+			return nil
+		}
+		if err := os.MkdirAll(target, 0755); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", e.name, err)
+		}
+		ex.dirs = append(ex.dirs, e)
+		ex.dirTargets = append(ex.dirTargets, target)
+	case entryFile:
+		// Never write through a link left at the target path
+		if err := removeFile(target); err != nil {
+			return err
+		}
+		if err := writeFile(target, e, ex.options.Sync); err != nil {
+			return fmt.Errorf("failed to extract %s: %w", e.name, err)
+		}
+	case entrySymlink:
+		if err := ex.symlinks.add(e.name, e.linkname); err != nil {
+			return err
+		}
+		if err := removeFile(target); err != nil {
+			return err
+		}
+		if err := os.Symlink(e.linkname, target); err != nil {
+			return fmt.Errorf("failed to create symlink %s: %w", e.name, err)
+		}
+	case entryHardlink:
+		source, err := SafeJoin(ex.destination, e.linkname)
+		if err != nil {
+			return err
+		}
+		if err := removeFile(target); err != nil {
+			return err
+		}
+		if err := os.Link(source, target); err != nil {
+			return fmt.Errorf("failed to create hard link %s: %w", e.name, err)
+		}
+	case entrySpecial:
+		return fmt.Errorf("%w: %q is a device or special file", ErrUnsafeEntry, e.name)
+	}
+
+	return nil
+}
+
+// finish restores the directories last, extracting their contents changes the mtime.
+func (ex *extractor) finish() error {
+	for i := len(ex.dirs) - 1; i >= 0; i-- {
+		if err := restoreAttributes(ex.dirTargets[i], ex.dirs[i]); err != nil {
+			return fmt.Errorf("failed to restore attributes of %s: %w", ex.dirs[i].name, err)
+		}
+		if ex.options.Sync {
+			if err := syncPath(ex.dirTargets[i]); err != nil {
+				return fmt.Errorf("failed to sync %s: %w", ex.dirs[i].name, err)
+			}
+		}
+	}
+	return nil
+}
+
+// writeFile writes the contents of one entry to target and closes the file
+// right away, so extracting large archives keeps a single file open.
+func writeFile(target string, e entry, sync bool) error {
+	outFile, err := os.OpenFile(target, os.O_CREATE|os.O_EXCL|os.O_WRONLY, e.mode.Perm())
+	if err != nil {
+		return err
+	}
+
+	_, err = io.Copy(outFile, e.contents)
+	if err == nil && sync {
+		err = outFile.Sync()
+	}
+	if closeErr := outFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+
+	return restoreAttributes(target, e)
+}
+
+func syncPath(path string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return file.Sync()
+}
+
+// removeFile deletes whatever non-directory entry exists at path.
+func removeFile(path string) error {
+	info, err := os.Lstat(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if info.IsDir() {
+		return fmt.Errorf("%w: %q is an existing directory", ErrUnsafeEntry, path)
+	}
+	return os.Remove(path)
+}
+
+// restoreAttributes applies the permissions and modification time stored in
+// the archive entry to path.
+func restoreAttributes(path string, e entry) error {
+	if err := os.Chmod(path, e.mode.Perm()); err != nil {
+		return err
+	}
+	if e.modTime.IsZero() {
+		return nil
+	}
+	return os.Chtimes(path, e.modTime, e.modTime)
+}
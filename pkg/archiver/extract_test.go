@@ -0,0 +1,112 @@
+package archiver
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func withAttributes(e fixtureEntry, mode os.FileMode, modTime time.Time) fixtureEntry {
+	e.mode, e.modTime = mode, modTime
+	return e
+}
+
+func TestExtractRestoresAttributes(t *testing.T) {
+	fileTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	dirTime := time.Date(2019, 6, 7, 8, 9, 10, 0, time.UTC)
+	rootTime := time.Date(2001, 1, 1, 0, 0, 0, 0, time.UTC)
+	entries := []fixtureEntry{
+		withAttributes(dir("./"), 0700, rootTime),
+		withAttributes(dir("bin/"), 0750, dirTime),
+		withAttributes(file("bin/tool", "tool"), 0755, fileTime),
+		withAttributes(file("secret", "secret"), 0600, fileTime),
+		symlink("bin/link", "tool"),
+	}
+
+	for format, craft := range crafters {
+		for _, sync := range []bool{false, true} {
+			destination := t.TempDir()
+			if err := os.Chmod(destination, 0755); err != nil {
+				t.Fatal(err)
+			}
+			before, err := os.Stat(destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := Extract(craft(t, entries), destination, ExtractOptions{Sync: sync}); err != nil {
+				t.Fatalf("%s, sync %v: %v", format, sync, err)
+			}
+
+			for name, want := range map[string]struct {
+				mode    os.FileMode
+				modTime time.Time
+			}{
+				"bin":      {os.ModeDir | 0750, dirTime},
+				"bin/tool": {0755, fileTime},
+				"secret":   {0600, fileTime},
+			} {
+				info, err := os.Lstat(filepath.Join(destination, name))
+				if err != nil {
+					t.Fatalf("%s, sync %v: %v", format, sync, err)
+				}
+				if info.Mode() != want.mode {
+					t.Errorf("%s, sync %v: %s has mode %v, want %v", format, sync, name, info.Mode(), want.mode)
+				}
+				if !info.ModTime().Equal(want.modTime) {
+					t.Errorf("%s, sync %v: %s has mtime %v, want %v", format, sync, name, info.ModTime(), want.modTime)
+				}
+			}
+
+			linkname, err := os.Readlink(filepath.Join(destination, "bin", "link"))
+			if err != nil || linkname != "tool" {
+				t.Errorf("%s, sync %v: symlink points to %q, %v", format, sync, linkname, err)
+			}
+
+			// The root entry must not change the destination directory
+			after, err := os.Stat(destination)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if after.Mode() != before.Mode() {
+				t.Errorf("%s, sync %v: destination mode changed from %v to %v", format, sync, before.Mode(), after.Mode())
+			}
+			if after.ModTime().Equal(rootTime) {
+				t.Errorf("%s, sync %v: destination got the mtime of the root entry", format, sync)
+			}
+		}
+	}
+}
+
+func TestExtractClosesFiles(t *testing.T) {
+	if _, err := os.Stat("/proc/self/fd"); err != nil {
+		t.Skip("open files cannot be counted on this system")
+	}
+	openFiles := func() int {
+		entries, err := os.ReadDir("/proc/self/fd")
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(entries)
+	}
+
+	entries := []fixtureEntry{dir("files/")}
+	for i := 0; i < 200; i++ {
+		entries = append(entries, file(fmt.Sprintf("files/%03d", i), "x"))
+	}
+
+	for format, craft := range crafters {
+		arch := craft(t, entries)
+		for _, sync := range []bool{false, true} {
+			before := openFiles()
+			if err := Extract(arch, t.TempDir(), ExtractOptions{Sync: sync}); err != nil {
+				t.Fatalf("%s, sync %v: %v", format, sync, err)
+			}
+			if after := openFiles(); after > before {
+				t.Errorf("%s, sync %v: %d files left open", format, sync, after-before)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package archiver
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Format is the container and compression of a package archive.
+type Format string
+
+const (
+	FormatZip    Format = "zip"
+	FormatTarGz  Format = "tar.gz"
+	FormatTarZst Format = "tar.zst"
+)
+
+// DefaultFormat is used when no format is given.
+const DefaultFormat = FormatZip
+
+// Options change how Archive writes a package.
+type Options struct {
+	Format Format
+	// Level is the compression level: 1-9 for zip and tar.gz, 1-22 for
+	// tar.zst. Zero selects the default level of the format.
+	Level int
+	// Reproducible makes the archive depend only on the file names and
+	// contents: every entry gets the same modification time, owners are
+	// dropped and modes are normalized to 0755 or 0644.
+	Reproducible bool
+	// ModTime is the modification time of every entry in reproducible mode.
+	// When zero, SOURCE_DATE_EPOCH is used, or 1980-01-01 if it is not set.
+	ModTime time.Time
+}
+
+// reproducibleModTime returns the modification time used for every entry of
+// a reproducible archive.
+func (o Options) reproducibleModTime() (time.Time, error) {
+	if !o.ModTime.IsZero() {
+		return o.ModTime.UTC(), nil
+	}
+	if epoch := os.Getenv("SOURCE_DATE_EPOCH"); epoch != "" {
+		seconds, err := strconv.ParseInt(epoch, 10, 64)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid SOURCE_DATE_EPOCH %q: %v", epoch, err)
+		}
+		return time.Unix(seconds, 0).UTC(), nil
+	}
+	// The earliest time a ZIP archive can store
+	return time.Date(1980, time.January, 1, 0, 0, 0, 0, time.UTC), nil
+}
+
+// normalizeMode returns the mode stored in a reproducible archive.
+func normalizeMode(mode os.FileMode) os.FileMode {
+	switch {
+	case mode&os.ModeSymlink != 0:
+		return os.ModeSymlink | 0777
+	case mode.IsDir():
+		return os.ModeDir | 0755
+	case mode&0111 != 0:
+		return 0755
+	default:
+		return 0644
+	}
+}
+
+func ParseFormat(format string) (Format, error) {
+	switch Format(format) {
+	case "":
+		return DefaultFormat, nil
+	case FormatZip, FormatTarGz, FormatTarZst:
+		return Format(format), nil
+	case "tgz":
+		return FormatTarGz, nil
+	case "tzst":
+		return FormatTarZst, nil
+	default:
+		return "", fmt.Errorf("unsupported archive format: %s (supported: zip, tar.gz, tar.zst)", format)
+	}
+}
+
+// Extension returns the file name extension of the format, including the dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// DetectFormat tells the format of an archive from its first bytes.
+func DetectFormat(arch []byte) (Format, error) {
+	switch {
+	case bytes.HasPrefix(arch, []byte("PK\x03\x04")), bytes.HasPrefix(arch, []byte("PK\x05\x06")):
+		return FormatZip, nil
+	case bytes.HasPrefix(arch, []byte{0x1f, 0x8b}):
+		return FormatTarGz, nil
+	case bytes.HasPrefix(arch, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return FormatTarZst, nil
+	default:
+		return "", fmt.Errorf("unknown archive format")
+	}
+}
+
+func (o Options) validate() error {
+	switch o.Format {
+	case FormatZip, FormatTarGz:
+		if o.Level < 0 || o.Level > 9 {
+			return fmt.Errorf("compression level %d out of range 1-9 for %s", o.Level, o.Format)
+		}
+	case FormatTarZst:
+		if o.Level < 0 || o.Level > 22 {
+			return fmt.Errorf("compression level %d out of range 1-22 for %s", o.Level, o.Format)
+		}
+	default:
+		return fmt.Errorf("unsupported archive format: %s", o.Format)
+	}
+	return nil
+}
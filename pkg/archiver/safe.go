@@ -0,0 +1,145 @@
+package archiver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrUnsafeEntry is returned for archive entries that would be written outside
+// of the destination directory or are of a type that is never extracted.
+var ErrUnsafeEntry = errors.New("unsafe archive entry")
+
+// SafeJoin returns the path of the archive entry name inside root. It fails if
+// name is absolute, escapes root with "..", or has a symlink among its parent
+// directories, so that an entry can never be written through a link.
+func SafeJoin(root, name string) (string, error) {
+	cleaned, err := checkName(name)
+	if err != nil {
+		return "", err
+	}
+
+	parent := root
+	for _, part := range parentDirs(cleaned) {
+		parent = filepath.Join(parent, part)
+		info, err := os.Lstat(parent)
+		if os.IsNotExist(err) {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("%w: path %q is inside the symlink %s", ErrUnsafeEntry, name, parent)
+		}
+	}
+
+	return filepath.Join(root, cleaned), nil
+}
+
+// checkName returns the cleaned entry name, failing for absolute names and
+// names that escape the destination.
+func checkName(name string) (string, error) {
+	if name == "" || filepath.IsAbs(name) || strings.HasPrefix(name, "/") || filepath.VolumeName(name) != "" {
+		return "", fmt.Errorf("%w: absolute path %q", ErrUnsafeEntry, name)
+	}
+	cleaned := filepath.Clean(filepath.FromSlash(name))
+	if escapes(cleaned) {
+		return "", fmt.Errorf("%w: path %q escapes the destination", ErrUnsafeEntry, name)
+	}
+	return cleaned, nil
+}
+
+// parentDirs returns the parent directory components of a cleaned path.
+func parentDirs(cleaned string) []string {
+	dir := filepath.Dir(cleaned)
+	if dir == "." {
+		return nil
+	}
+	return strings.Split(dir, string(filepath.Separator))
+}
+
+// CheckLink fails if a symlink stored as name and pointing to linkname would
+// point outside of the destination directory.
+func CheckLink(name, linkname string) error {
+	if linkname == "" || filepath.IsAbs(linkname) || strings.HasPrefix(linkname, "/") || filepath.VolumeName(linkname) != "" {
+		return fmt.Errorf("%w: symlink %q points to absolute path %q", ErrUnsafeEntry, name, linkname)
+	}
+	resolved := filepath.Join(filepath.Dir(filepath.FromSlash(name)), filepath.FromSlash(linkname))
+	if escapes(resolved) {
+		return fmt.Errorf("%w: symlink %q points outside the destination to %q", ErrUnsafeEntry, name, linkname)
+	}
+	return nil
+}
+
+// escapes reports whether the cleaned relative path leaves its base directory.
+func escapes(path string) bool {
+	path = filepath.Clean(path)
+	return path == ".." || strings.HasPrefix(path, ".."+string(filepath.Separator))
+}
+
+// linkSet collects the symlinks of one archive. Each link is checked with
+// CheckLink, and no link may point through another link of the archive:
+// "a/b/l/.." leaves the directory a/b/l seems to be in when a/b/l is itself a
+// link, so a chain of links that each look safe could still point outside
+// the destination. Links are checked against each other in both directions,
+// so the order of the entries does not matter.
+type linkSet map[string]string
+
+func (s linkSet) add(name, linkname string) error {
+	if err := CheckLink(name, linkname); err != nil {
+		return err
+	}
+	cleaned, err := checkName(name)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range traversedDirs(cleaned, linkname) {
+		if _, ok := s[dir]; ok {
+			return fmt.Errorf("%w: symlink %q points through the symlink %s", ErrUnsafeEntry, name, dir)
+		}
+	}
+	for other, otherLinkname := range s {
+		for _, dir := range traversedDirs(other, otherLinkname) {
+			if dir == cleaned {
+				return fmt.Errorf("%w: symlink %q points through the symlink %s", ErrUnsafeEntry, other, name)
+			}
+		}
+	}
+
+	s[cleaned] = linkname
+	return nil
+}
+
+// traversedDirs returns the paths, relative to the destination, of the
+// directories passed through when the link name -> linkname is followed
+// lexically. The final component, the file the link points to, is not
+// included.
+func traversedDirs(name, linkname string) []string {
+	components := []string{}
+	for _, part := range append(parentDirs(name), strings.Split(filepath.FromSlash(linkname), string(filepath.Separator))...) {
+		if part != "" && part != "." {
+			components = append(components, part)
+		}
+	}
+	if len(components) > 0 {
+		components = components[:len(components)-1]
+	}
+
+	dirs := []string{}
+	stack := []string{}
+	for _, part := range components {
+		if part == ".." {
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+			continue
+		}
+		stack = append(stack, part)
+		dirs = append(dirs, filepath.Join(stack...))
+	}
+	return dirs
+}
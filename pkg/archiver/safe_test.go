@@ -0,0 +1,178 @@
+package archiver
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// fixtureEntry is one entry of an archive crafted by a test.
+type fixtureEntry struct {
+	name     string
+	kind     entryKind
+	linkname string
+	contents string
+	// mode and modTime are left to the defaults of the format when zero
+	mode    os.FileMode
+	modTime time.Time
+}
+
+func dir(name string) fixtureEntry {
+	return fixtureEntry{name: name, kind: entryDir}
+}
+
+func file(name, contents string) fixtureEntry {
+	return fixtureEntry{name: name, kind: entryFile, contents: contents}
+}
+
+func symlink(name, linkname string) fixtureEntry {
+	return fixtureEntry{name: name, kind: entrySymlink, linkname: linkname}
+}
+
+// craftTarGz writes the entries as they are, without any of the checks the
+// archiver makes.
+func craftTarGz(t *testing.T, entries []fixtureEntry) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	gzipWriter := gzip.NewWriter(buf)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for _, e := range entries {
+		header := &tar.Header{Name: e.name, Mode: 0644}
+		switch e.kind {
+		case entryDir:
+			header.Typeflag, header.Mode = tar.TypeDir, 0755
+		case entryFile:
+			header.Typeflag, header.Size = tar.TypeReg, int64(len(e.contents))
+		case entrySymlink:
+			header.Typeflag, header.Linkname, header.Mode = tar.TypeSymlink, e.linkname, 0777
+		}
+		if e.mode != 0 {
+			header.Mode = int64(e.mode.Perm())
+		}
+		header.ModTime = e.modTime
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write([]byte(e.contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func craftZip(t *testing.T, entries []fixtureEntry) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	zipWriter := zip.NewWriter(buf)
+	for _, e := range entries {
+		header := &zip.FileHeader{Name: e.name, Method: zip.Store}
+		contents := e.contents
+		switch e.kind {
+		case entryDir:
+			header.SetMode(os.ModeDir | 0755)
+		case entryFile:
+			header.SetMode(0644)
+		case entrySymlink:
+			header.SetMode(os.ModeSymlink | 0777)
+			contents = e.linkname
+		}
+		if e.mode != 0 {
+			header.SetMode(header.Mode().Type() | e.mode.Perm())
+		}
+		if !e.modTime.IsZero() {
+			header.Modified = e.modTime
+		}
+		w, err := zipWriter.CreateHeader(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(contents)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+var crafters = map[string]func(*testing.T, []fixtureEntry) []byte{
+	"tar.gz": craftTarGz,
+	"zip":    craftZip,
+}
+
+func TestUnsafeArchivesAreRejected(t *testing.T) {
+	tests := map[string][]fixtureEntry{
+		"parent path":        {file("../evil", "x")},
+		"nested parent path": {dir("a/"), file("a/../../evil", "x")},
+		"absolute path":      {file("/tmp/evil", "x")},
+		"absolute link":      {symlink("l", "/etc")},
+		"escaping link":      {dir("a/"), symlink("a/l", "../../evil")},
+		"write through link": {dir("a/"), symlink("a/l", ".."), file("a/l/evil", "x")},
+		"link chain": {
+			dir("a/"), dir("a/b/"),
+			symlink("a/b/l", "../.."),
+			symlink("m", "a/b/l/.."),
+		},
+		"link chain, link last": {
+			dir("a/"), dir("a/b/"),
+			symlink("m", "a/b/l/.."),
+			symlink("a/b/l", "../.."),
+		},
+	}
+
+	for format, craft := range crafters {
+		for name, entries := range tests {
+			arch := craft(t, entries)
+			if err := Check(arch); !errors.Is(err, ErrUnsafeEntry) {
+				t.Errorf("%s, %s: Check returned %v, want ErrUnsafeEntry", format, name, err)
+			}
+
+			root := t.TempDir()
+			destination := filepath.Join(root, "dest")
+			if err := Extract(arch, destination, ExtractOptions{}); !errors.Is(err, ErrUnsafeEntry) {
+				t.Errorf("%s, %s: Extract returned %v, want ErrUnsafeEntry", format, name, err)
+			}
+			if _, err := os.Lstat(filepath.Join(root, "evil")); err == nil {
+				t.Errorf("%s, %s: a file was written outside the destination", format, name)
+			}
+		}
+	}
+}
+
+func TestSafeLinksAreAccepted(t *testing.T) {
+	entries := []fixtureEntry{
+		dir("a/"), dir("a/b/"),
+		file("a/file", "x"),
+		symlink("a/b/up", "../file"),
+		symlink("a/b/root", "../.."),
+		symlink("top", "a/b/up"),
+	}
+
+	for format, craft := range crafters {
+		arch := craft(t, entries)
+		if err := Check(arch); err != nil {
+			t.Errorf("%s: Check returned %v", format, err)
+		}
+		destination := t.TempDir()
+		if err := Extract(arch, destination, ExtractOptions{}); err != nil {
+			t.Fatalf("%s: Extract returned %v", format, err)
+		}
+		contents, err := os.ReadFile(filepath.Join(destination, "top"))
+		if err != nil || string(contents) != "x" {
+			t.Errorf("%s: reading through the links gave %q, %v", format, contents, err)
+		}
+	}
+}
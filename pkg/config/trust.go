@@ -0,0 +1,100 @@
+package config
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ed25519"
+	"fmt"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// TrustConfig holds the keys installed packages must be signed with.
+type TrustConfig struct {
+	// TrustedKeys are read from the keyring file named by GOPM_TRUSTED_KEYS.
+	TrustedKeys []ed25519.PublicKey
+}
+
+// RequireSignatures reports whether unsigned and untrusted packages are
+// rejected. Signatures are required as soon as a keyring is configured.
+func (c TrustConfig) RequireSignatures() bool {
+	return len(c.TrustedKeys) > 0
+}
+
+// ConfigureTrust reads the keyring named by GOPM_TRUSTED_KEYS. The keyring
+// uses the authorized_keys format: one ssh-ed25519 public key per line,
+// blank lines and lines starting with # are ignored.
+func ConfigureTrust() (TrustConfig, error) {
+	config := TrustConfig{}
+
+	keyringPath := os.Getenv("GOPM_TRUSTED_KEYS")
+	if keyringPath == "" {
+		return config, nil
+	}
+
+	content, err := os.ReadFile(keyringPath)
+	if err != nil {
+		return config, fmt.Errorf("failed to read trusted keys: %w", err)
+	}
+
+	scanner := bufio.NewScanner(bytes.NewReader(content))
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := bytes.TrimSpace(scanner.Bytes())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+		publicKey, _, _, _, err := ssh.ParseAuthorizedKey(line)
+		if err != nil {
+			return config, fmt.Errorf("%s:%d: failed to parse public key: %w", keyringPath, lineNumber, err)
+		}
+		key, err := ed25519PublicKey(publicKey)
+		if err != nil {
+			return config, fmt.Errorf("%s:%d: %w", keyringPath, lineNumber, err)
+		}
+		config.TrustedKeys = append(config.TrustedKeys, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return config, fmt.Errorf("failed to read trusted keys: %w", err)
+	}
+	if len(config.TrustedKeys) == 0 {
+		return config, fmt.Errorf("no keys found in %s", keyringPath)
+	}
+
+	return config, nil
+}
+
+// LoadSigningKey reads an unencrypted Ed25519 private key in OpenSSH or
+// PKCS#8 PEM format, as written by ssh-keygen -t ed25519 -N "".
+func LoadSigningKey(keyPath string) (ed25519.PrivateKey, error) {
+	content, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read signing key: %w", err)
+	}
+
+	rawKey, err := ssh.ParseRawPrivateKey(content)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse signing key: %w", err)
+	}
+
+	switch key := rawKey.(type) {
+	case *ed25519.PrivateKey:
+		return *key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	default:
+		return nil, fmt.Errorf("signing key %s is not an Ed25519 key", keyPath)
+	}
+}
+
+func ed25519PublicKey(publicKey ssh.PublicKey) (ed25519.PublicKey, error) {
+	cryptoKey, ok := publicKey.(ssh.CryptoPublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported public key type %s", publicKey.Type())
+	}
+	key, ok := cryptoKey.CryptoPublicKey().(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("public key of type %s is not an Ed25519 key", publicKey.Type())
+	}
+	return key, nil
+}
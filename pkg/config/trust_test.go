@@ -0,0 +1,95 @@
+package config
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"golang.org/x/crypto/ssh"
+)
+
+func authorizedKey(t *testing.T, key interface{}) string {
+	t.Helper()
+	publicKey, err := ssh.NewPublicKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey)))
+}
+
+func writeKeyring(t *testing.T, lines ...string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "trusted_keys")
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConfigureTrust(t *testing.T) {
+	first, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("GOPM_TRUSTED_KEYS", "")
+	trust, err := ConfigureTrust()
+	if err != nil || trust.RequireSignatures() {
+		t.Errorf("without a keyring: got %+v, %v", trust, err)
+	}
+
+	t.Setenv("GOPM_TRUSTED_KEYS", writeKeyring(t,
+		"# release keys",
+		authorizedKey(t, first)+" release@example.com",
+		"",
+		"   "+authorizedKey(t, second),
+	))
+	trust, err = ConfigureTrust()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []ed25519.PublicKey{first, second}; !reflect.DeepEqual(trust.TrustedKeys, want) {
+		t.Errorf("got keys %v, want %v", trust.TrustedKeys, want)
+	}
+	if !trust.RequireSignatures() {
+		t.Error("signatures are not required with a keyring")
+	}
+}
+
+func TestConfigureTrustErrors(t *testing.T) {
+	ecdsaKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed25519Key, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		keyring string
+		want    string
+	}{
+		"malformed key":   {writeKeyring(t, authorizedKey(t, ed25519Key), "ssh-ed25519 not-base64"), ":2: failed to parse public key"},
+		"not ed25519":     {writeKeyring(t, authorizedKey(t, &ecdsaKey.PublicKey)), ":1: public key of type ecdsa-sha2-nistp256 is not an Ed25519 key"},
+		"only comments":   {writeKeyring(t, "# no keys yet", ""), "no keys found"},
+		"missing keyring": {filepath.Join(t.TempDir(), "missing"), "failed to read trusted keys"},
+	}
+	for name, test := range tests {
+		t.Setenv("GOPM_TRUSTED_KEYS", test.keyring)
+		_, err := ConfigureTrust()
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want %q", name, err, test.want)
+		}
+	}
+}
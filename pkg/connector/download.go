@@ -1,130 +1,175 @@
 package connector
 
 import (
+	"encoding/json"
+	"errors"
 	"fmt"
-	"io/ioutil"
-	"time"
+	"io"
+	"os"
+	"path"
+	"strings"
 
-	"github.com/bpva/gopm/pkg/packager"
+	"github.com/bpva/gopm/pkg/archiver"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
-func DownloadUpdates(config packager.UpdateConfig, sshClient *ssh.Client) (arch []byte, varsions map[string]string, err error) {
-	session, err := sshClient.NewSession()
-	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create SSH session: %w", err)
-	}
-	defer session.Close()
+// archiveBaseName is the name, without extension, the uploaded archive of a
+// package version is stored under on the server.
+const archiveBaseName = "package"
+
+// archiveDir returns the directory holding the uploaded archive of a package
+// version. It is hidden so it is not listed as a version of the package.
+func archiveDir(packageName, version string) string {
+	return path.Join("gopm_packages", packageName, ".archives", version)
+}
 
+// archivePath returns where the archive of a package version is stored on the
+// server.
+func archivePath(packageName, version string, format archiver.Format) string {
+	return path.Join(archiveDir(packageName, version), archiveBaseName+format.Extension())
+}
+
+// Download is the archive of a package version as it is stored on the server.
+type Download struct {
+	Archive []byte
+	// Checksum is the SHA-256 of the archive recorded when it was uploaded,
+	// empty when none was recorded.
+	Checksum string
+	// Legacy is set for versions published before their archive was kept on
+	// the server. Archive is then a tar.gz of the version directory built on
+	// the server, and there is no checksum to check it against.
+	Legacy bool
+}
+
+// errNoStoredArchive is returned by downloadArchive for versions published
+// before their archive was kept on the server.
+var errNoStoredArchive = errors.New("the version was published without its archive")
+
+// DownloadPackages fetches the stored archive of every given package version
+// from the remote server, as it was uploaded, with its recorded checksum.
+// Versions published without their archive are archived on the server as
+// before. The archives are returned by package name.
+func DownloadPackages(versions map[string]string, sshClient *ssh.Client) (map[string]Download, error) {
 	sftpClient, err := sftp.NewClient(sshClient)
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create SFTP client: %w", err)
+		return nil, fmt.Errorf("failed to create SFTP client: %w", err)
 	}
 	defer sftpClient.Close()
 
-	// Create a map to store the last suitable versions for each package
-	lastSuitableVersions := map[string]string{}
-
-	// Iterate over the updates in the config
-	for _, update := range config.Updates {
-		packageName := update.Name
-		packageDir := fmt.Sprintf("gopm_packages/%s", packageName)
-
-		versions, err := packager.FindSuitableVersions(packageDir, update.Version, update.Operator, sshClient)
-		if err != nil {
-			return []byte{}, nil, fmt.Errorf("failed to find suitable versions for package %s: %w", packageName, err)
+	downloads := map[string]Download{}
+	for packageName, version := range versions {
+		download, err := downloadArchive(sftpClient, packageName, version)
+		if errors.Is(err, errNoStoredArchive) {
+			download, err = downloadLegacyArchive(sshClient, packageName, version)
 		}
-
-		if len(versions) > 0 {
-			lastSuitableVersions[packageName] = versions[0]
-		} else {
-			return []byte{}, nil, fmt.Errorf("no suitable versions found for package %s", packageName)
+		if err != nil {
+			return nil, fmt.Errorf("failed to download %s %s: %w", packageName, version, err)
 		}
+		downloads[packageName] = download
 	}
 
-	// Create a temporary directory on the remote server
-	tempDir := fmt.Sprintf("/tmp/%d", time.Now().UnixNano())
-	err = session.Run(fmt.Sprintf("mkdir %s", tempDir))
+	return downloads, nil
+}
+
+// downloadArchive reads the format of the package version from its
+// metadata.json and downloads the archive stored in that format. It returns
+// errNoStoredArchive when there is no metadata.json, no format in it or no
+// archive in that format.
+func downloadArchive(sftpClient *sftp.Client, packageName, version string) (Download, error) {
+	metadataFile, err := sftpClient.Open(path.Join("gopm_packages", packageName, version, "metadata.json"))
+	if os.IsNotExist(err) {
+		return Download{}, errNoStoredArchive
+	}
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create temporary directory on the remote server: %w", err)
+		return Download{}, fmt.Errorf("failed to open metadata.json: %w", err)
 	}
-
-	// Reopen the session after creating the temporary directory
-	session, err = sshClient.NewSession()
+	var metadata struct {
+		Format archiver.Format `json:"format"`
+	}
+	err = json.NewDecoder(metadataFile).Decode(&metadata)
+	metadataFile.Close()
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create SSH session: %w", err)
+		return Download{}, fmt.Errorf("failed to parse metadata.json: %w", err)
+	}
+	if metadata.Format == "" {
+		return Download{}, errNoStoredArchive
 	}
-	session.Close()
 
-	// Copy the needed packages to the temporary directory
-	for packageName, version := range lastSuitableVersions {
-		sourceDir := fmt.Sprintf("gopm_packages/%s/%s", packageName, version)
-		destinationDir := fmt.Sprintf("%s/%s/%s", tempDir, packageName, version)
-		session, err = sshClient.NewSession()
-		if err != nil {
-			return []byte{}, nil, fmt.Errorf("failed to create SSH session: %w", err)
-		}
-		err = session.Run(fmt.Sprintf("mkdir -p %s", destinationDir))
-		session.Close()
-		if err != nil {
-			return []byte{}, nil, fmt.Errorf("failed to create directory %s on the remote server: %w", destinationDir, err)
-		}
-		session, err = sshClient.NewSession()
-		if err != nil {
-			return []byte{}, nil, fmt.Errorf("failed to create SSH session: %w", err)
-		}
-		err = session.Run(fmt.Sprintf("cp -r %s/. %s", sourceDir, destinationDir))
-		session.Close()
-		if err != nil {
-			return []byte{}, nil, fmt.Errorf("failed to copy package %s/%s to the remote server: %w", packageName, version, err)
+	remoteFile, err := sftpClient.Open(archivePath(packageName, version, metadata.Format))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return Download{}, errNoStoredArchive
 		}
+		return Download{}, fmt.Errorf("failed to open the remote archive: %w", err)
 	}
+	defer remoteFile.Close()
 
-	// Archive the temporary directory
-	tempFilePath := fmt.Sprintf("%s.tar.gz", tempDir)
-	session, err = sshClient.NewSession()
+	arch, err := io.ReadAll(remoteFile)
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create SSH session: %w", err)
+		return Download{}, fmt.Errorf("failed to read the remote archive: %w", err)
 	}
-	err = session.Run(fmt.Sprintf("tar -czvf %s -C %s .", tempFilePath, tempDir))
-	session.Close()
+
+	format, err := archiver.DetectFormat(arch)
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create archive on the remote server: %w", err)
+		return Download{}, err
+	}
+	if format != metadata.Format {
+		return Download{}, fmt.Errorf("archive is %s, but metadata.json records %s", format, metadata.Format)
 	}
 
-	// Read the archive using SFTP
-	remoteFile, err := sftpClient.Open(tempFilePath)
+	checksum, err := readChecksum(sftpClient, checksumPath(packageName, version, metadata.Format))
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to open the remote file: %w", err)
+		return Download{}, err
 	}
-	defer remoteFile.Close()
 
-	// Read the file into a buffer
-	arch, err = ioutil.ReadAll(remoteFile)
+	return Download{Archive: arch, Checksum: checksum}, nil
+}
+
+// downloadLegacyArchive archives the directory of a package version on the
+// server, the way versions were downloaded before their archive was kept.
+func downloadLegacyArchive(sshClient *ssh.Client, packageName, version string) (Download, error) {
+	session, err := sshClient.NewSession()
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to read the remote file: %w", err)
+		return Download{}, fmt.Errorf("failed to create SSH session: %w", err)
 	}
+	defer session.Close()
 
-	// Remove the temporary directory and archive file
-	session, err = sshClient.NewSession()
+	versionDir := path.Join("gopm_packages", packageName, version)
+	arch, err := session.Output(fmt.Sprintf("tar -czf - -C %s .", versionDir))
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create SSH session: %w", err)
+		return Download{}, fmt.Errorf("failed to archive %s on the remote server: %w", versionDir, err)
 	}
-	err = session.Run(fmt.Sprintf("rm -rf %s", tempDir))
-	session.Close()
-	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to remove the temporary directory: %w", err)
+
+	return Download{Archive: arch, Legacy: true}, nil
+}
+
+// checksumPath returns where the checksum of a stored archive is recorded.
+// The file uses the sha256sum format, so it can be checked on the server with
+// sha256sum -c.
+func checksumPath(packageName, version string, format archiver.Format) string {
+	return archivePath(packageName, version, format) + ".sha256"
+}
+
+// readChecksum reads the digest from a checksum file, or "" when there is
+// none.
+func readChecksum(sftpClient *sftp.Client, checksumFile string) (string, error) {
+	file, err := sftpClient.Open(checksumFile)
+	if os.IsNotExist(err) {
+		return "", nil
 	}
-	session, err = sshClient.NewSession()
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to create SSH session: %w", err)
+		return "", fmt.Errorf("failed to open the archive checksum: %w", err)
 	}
-	err = session.Run(fmt.Sprintf("rm %s", tempFilePath))
-	session.Close()
+	defer file.Close()
+
+	content, err := io.ReadAll(io.LimitReader(file, 1024))
 	if err != nil {
-		return []byte{}, nil, fmt.Errorf("failed to remove the archive file: %w", err)
+		return "", fmt.Errorf("failed to read the archive checksum: %w", err)
 	}
-
-	return arch, lastSuitableVersions, nil
+	fields := strings.Fields(string(content))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	return fields[0], nil
 }
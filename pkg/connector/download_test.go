@@ -0,0 +1,99 @@
+package connector
+
+import (
+	"errors"
+	"net"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/bpva/gopm/pkg/archiver"
+	"github.com/pkg/sftp"
+)
+
+// memoryServer returns an SFTP client connected to an in-memory server.
+func memoryServer(t *testing.T) *sftp.Client {
+	t.Helper()
+	serverConn, clientConn := net.Pipe()
+	server := sftp.NewRequestServer(serverConn, sftp.InMemHandler())
+	go server.Serve()
+	client, err := sftp.NewClientPipe(clientConn, clientConn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		client.Close()
+		server.Close()
+	})
+	return client
+}
+
+func writeRemote(t *testing.T, client *sftp.Client, name string, content []byte) {
+	t.Helper()
+	if err := client.MkdirAll(path.Dir(name)); err != nil {
+		t.Fatal(err)
+	}
+	file, err := client.Create(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	if _, err := file.Write(content); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDownloadLegacyLayout(t *testing.T) {
+	tests := map[string]map[string]string{
+		"no metadata.json": {
+			"gopm_packages/p/1.0.0/tool": "tool",
+		},
+		"metadata.json without format": {
+			"gopm_packages/p/1.0.0/metadata.json": `{"name": "p", "version": "1.0.0"}`,
+		},
+		"no stored archive": {
+			"gopm_packages/p/1.0.0/metadata.json": `{"name": "p", "version": "1.0.0", "format": "tar.gz"}`,
+		},
+	}
+	for name, files := range tests {
+		client := memoryServer(t)
+		for file, content := range files {
+			writeRemote(t, client, file, []byte(content))
+		}
+		if _, err := downloadArchive(client, "p", "1.0.0"); !errors.Is(err, errNoStoredArchive) {
+			t.Errorf("%s: got error %v, want the legacy fallback", name, err)
+		}
+	}
+}
+
+func TestDownloadStoredArchive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "tool"), []byte("tool"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arch, err := archiver.ArchiveWithOptions(dir, archiver.Options{Format: archiver.FormatTarGz})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := memoryServer(t)
+	writeRemote(t, client, "gopm_packages/p/1.0.0/metadata.json", []byte(`{"name": "p", "version": "1.0.0", "format": "tar.gz"}`))
+	writeRemote(t, client, archivePath("p", "1.0.0", archiver.FormatTarGz), arch)
+	writeRemote(t, client, checksumPath("p", "1.0.0", archiver.FormatTarGz), []byte("abc123  package.tar.gz\n"))
+
+	download, err := downloadArchive(client, "p", "1.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if download.Legacy || download.Checksum != "abc123" || string(download.Archive) != string(arch) {
+		t.Errorf("got legacy %v, checksum %q, %d archive bytes", download.Legacy, download.Checksum, len(download.Archive))
+	}
+
+	// A stored archive in another format than metadata.json records is an error
+	writeRemote(t, client, "gopm_packages/p/1.0.0/metadata.json", []byte(`{"name": "p", "version": "1.0.0", "format": "zip"}`))
+	writeRemote(t, client, archivePath("p", "1.0.0", archiver.FormatZip), arch)
+	if _, err := downloadArchive(client, "p", "1.0.0"); err == nil || errors.Is(err, errNoStoredArchive) {
+		t.Errorf("mismatched format: got error %v", err)
+	}
+}
@@ -7,11 +7,27 @@ import (
 	"strconv"
 	"time"
 
+	"github.com/bpva/gopm/pkg/archiver"
+	"github.com/bpva/gopm/pkg/packager"
 	"github.com/pkg/sftp"
 	"golang.org/x/crypto/ssh"
 )
 
-func UploadAndUnpackArchive(arch []byte, sshClient *ssh.Client, packageName, packageVersion string) error {
+func UploadAndUnpackArchive(arch []byte, sshClient *ssh.Client, packageName, packageVersion string, overwrite bool) error {
+	// The archive is unpacked by unzip or tar on the server, which trust its paths
+	err := archiver.Check(arch)
+	if err != nil {
+		return fmt.Errorf("refusing to upload archive: %w", err)
+	}
+	format, err := archiver.DetectFormat(arch)
+	if err != nil {
+		return fmt.Errorf("failed to detect archive format: %w", err)
+	}
+	err = checkUnpackTool(sshClient, format)
+	if err != nil {
+		return err
+	}
+
 	session, err := sshClient.NewSession()
 	if err != nil {
 		return fmt.Errorf("failed to create SSH session: %w", err)
@@ -26,7 +42,7 @@ func UploadAndUnpackArchive(arch []byte, sshClient *ssh.Client, packageName, pac
 
 	// Generate a random archive name
 	r := rand.New(rand.NewSource(time.Now().UnixNano()))
-	archiveName := "archive_" + strconv.Itoa(r.Intn(10000)) + ".zip"
+	archiveName := "archive_" + strconv.Itoa(r.Intn(10000)) + format.Extension()
 
 	// Check if lock file exists
 	lockFileName := archiveName + ".lock"
@@ -58,16 +74,32 @@ func UploadAndUnpackArchive(arch []byte, sshClient *ssh.Client, packageName, pac
 		return fmt.Errorf("failed to upload archive: %w", err)
 	}
 
+	// The archive is kept as uploaded, downloads fetch it unchanged
 	targetDir := fmt.Sprintf("gopm_packages/%s/%s", packageName, packageVersion)
-	createCmd := fmt.Sprintf("mkdir -p %s && unzip -o %s -d %s", targetDir, archiveName, targetDir)
+	storedDir := archiveDir(packageName, packageVersion)
+	createCmd := fmt.Sprintf("mkdir -p %s %s && %s && mv %s %s",
+		targetDir, storedDir, unpackCommand(format, archiveName, targetDir),
+		archiveName, archivePath(packageName, packageVersion, format))
+	if overwrite {
+		// Files of the version being replaced must not be left behind
+		createCmd = fmt.Sprintf("rm -rf %s %s && %s", targetDir, storedDir, createCmd)
+	}
 	err = session.Run(createCmd)
 	if err != nil {
+		_ = sftpClient.Remove(archiveName)
+		_ = sftpClient.Remove(lockFileName)
 		return fmt.Errorf("failed to unpack archive on remote server: %w", err)
 	}
 
-	err = sftpClient.Remove(archiveName)
+	// Downloads check the archive against this before extracting it
+	checksumFile, err := sftpClient.Create(checksumPath(packageName, packageVersion, format))
+	if err != nil {
+		return fmt.Errorf("failed to create archive checksum: %w", err)
+	}
+	_, err = fmt.Fprintf(checksumFile, "%s  %s\n", packager.ArchiveChecksum(arch), archiveBaseName+format.Extension())
+	checksumFile.Close()
 	if err != nil {
-		return fmt.Errorf("failed to delete archive file: %w", err)
+		return fmt.Errorf("failed to write archive checksum: %w", err)
 	}
 
 	// Delete the lock file
@@ -78,3 +110,37 @@ func UploadAndUnpackArchive(arch []byte, sshClient *ssh.Client, packageName, pac
 
 	return nil
 }
+
+// unpackTools names the program the server needs to unpack each format.
+var unpackTools = map[archiver.Format]string{
+	archiver.FormatZip:    "unzip",
+	archiver.FormatTarGz:  "tar",
+	archiver.FormatTarZst: "zstd",
+}
+
+// checkUnpackTool fails when the server lacks the program unpacking format,
+// before anything is uploaded.
+func checkUnpackTool(sshClient *ssh.Client, format archiver.Format) error {
+	tool := unpackTools[format]
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	defer session.Close()
+	if err := session.Run(fmt.Sprintf("command -v %s >/dev/null", tool)); err != nil {
+		return fmt.Errorf("%s is not installed on the remote server, it is needed to unpack %s archives", tool, format)
+	}
+	return nil
+}
+
+// unpackCommand returns the shell command unpacking the archive on the server.
+func unpackCommand(format archiver.Format, archiveName, targetDir string) string {
+	switch format {
+	case archiver.FormatTarGz:
+		return fmt.Sprintf("tar -xzf %s -C %s", archiveName, targetDir)
+	case archiver.FormatTarZst:
+		return fmt.Sprintf("zstd -dc %s | tar -xf - -C %s", archiveName, targetDir)
+	default:
+		return fmt.Sprintf("unzip -o %s -d %s", archiveName, targetDir)
+	}
+}
@@ -0,0 +1,199 @@
+package packager
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const ChecksumsFileName = "checksums.json"
+
+// Checksums records the SHA-256 digest of every file in a package version.
+// It is stored as checksums.json next to dependencies.json in the package
+// directory and covers every other file of the package but signature.json.
+type Checksums struct {
+	// Files maps the slash separated path of every file within the package
+	// directory to its digest. A symlink is hashed by its target.
+	Files map[string]string `json:"files"`
+	// Digest is the package digest: the SHA-256 of the sorted
+	// "<digest>  <path>" lines of Files. It covers the package contents, not
+	// the archive, so it does not depend on the archive format. The SHA-256
+	// of the archive, which contains this file, is recorded next to the
+	// archive on the server, see ArchiveChecksum.
+	Digest string `json:"digest"`
+}
+
+// computeChecksums hashes every file in packageDir except checksums.json and
+// its signature.
+func computeChecksums(packageDir string) (Checksums, error) {
+	checksums := Checksums{Files: map[string]string{}}
+
+	err := filepath.Walk(packageDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		relPath, err := filepath.Rel(packageDir, path)
+		if err != nil {
+			return err
+		}
+		relPath = filepath.ToSlash(relPath)
+		if relPath == ChecksumsFileName || relPath == SignatureFileName {
+			return nil
+		}
+
+		digest, err := fileDigest(path, info)
+		if err != nil {
+			return err
+		}
+		checksums.Files[relPath] = digest
+		return nil
+	})
+	if err != nil {
+		return checksums, fmt.Errorf("failed to compute checksums: %w", err)
+	}
+
+	checksums.Digest = checksums.packageDigest()
+	return checksums, nil
+}
+
+func (c Checksums) packageDigest() string {
+	paths := make([]string, 0, len(c.Files))
+	for path := range c.Files {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	hash := sha256.New()
+	for _, path := range paths {
+		fmt.Fprintf(hash, "%s  %s\n", c.Files[path], path)
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+func fileDigest(path string, info os.FileInfo) (string, error) {
+	hash := sha256.New()
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(path)
+		if err != nil {
+			return "", err
+		}
+		hash.Write([]byte(target))
+		return hex.EncodeToString(hash.Sum(nil)), nil
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func createChecksumsFile(packageDir string) error {
+	checksums, err := computeChecksums(packageDir)
+	if err != nil {
+		return err
+	}
+
+	checksumsJSON, err := json.MarshalIndent(checksums, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal checksums to JSON: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(packageDir, ChecksumsFileName), checksumsJSON, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write checksums file: %w", err)
+	}
+
+	return nil
+}
+
+// ErrNoChecksums is returned by VerifyChecksums for packages published
+// without a checksums file.
+var ErrNoChecksums = errors.New("package has no " + ChecksumsFileName)
+
+// VerifyChecksums checks the files in packageDir against its checksums.json.
+// Every listed file must be present with the recorded digest and no other
+// file may be present.
+func VerifyChecksums(packageDir string) error {
+	content, err := os.ReadFile(filepath.Join(packageDir, ChecksumsFileName))
+	if os.IsNotExist(err) {
+		return ErrNoChecksums
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read checksums file: %w", err)
+	}
+
+	var expected Checksums
+	if err := json.Unmarshal(content, &expected); err != nil {
+		return fmt.Errorf("failed to parse checksums file: %w", err)
+	}
+	if expected.Digest != expected.packageDigest() {
+		return fmt.Errorf("checksums file is inconsistent with its package digest")
+	}
+
+	actual, err := computeChecksums(packageDir)
+	if err != nil {
+		return err
+	}
+
+	var problems []string
+	for path, digest := range expected.Files {
+		actualDigest, ok := actual.Files[path]
+		switch {
+		case !ok:
+			problems = append(problems, fmt.Sprintf("%s is missing", path))
+		case actualDigest != digest:
+			problems = append(problems, fmt.Sprintf("%s has checksum %s, expected %s", path, actualDigest, digest))
+		}
+	}
+	for path := range actual.Files {
+		if _, ok := expected.Files[path]; !ok {
+			problems = append(problems, fmt.Sprintf("%s is not listed in %s", path, ChecksumsFileName))
+		}
+	}
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		return fmt.Errorf("checksum mismatch: %s", strings.Join(problems, "; "))
+	}
+
+	return nil
+}
+
+// ErrNoArchiveChecksum is returned by VerifyArchive for archives stored
+// without the checksum recorded when they were uploaded.
+var ErrNoArchiveChecksum = errors.New("archive has no checksum")
+
+// ArchiveChecksum returns the SHA-256 of the archive bytes. It is recorded on
+// the server next to the uploaded archive.
+func ArchiveChecksum(arch []byte) string {
+	sum := sha256.Sum256(arch)
+	return hex.EncodeToString(sum[:])
+}
+
+// VerifyArchive checks a downloaded archive against the checksum recorded
+// when it was uploaded, before anything is extracted from it.
+func VerifyArchive(arch []byte, checksum string) error {
+	if checksum == "" {
+		return ErrNoArchiveChecksum
+	}
+	if actual := ArchiveChecksum(arch); actual != strings.ToLower(checksum) {
+		return fmt.Errorf("archive checksum mismatch: got %s, expected %s", actual, checksum)
+	}
+	return nil
+}
@@ -0,0 +1,98 @@
+package packager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/bpva/gopm/pkg/archiver"
+)
+
+func TestVerifyArchive(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("contents"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arch, err := archiver.Archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checksum := ArchiveChecksum(arch)
+
+	if err := VerifyArchive(arch, checksum); err != nil {
+		t.Errorf("untouched archive: %v", err)
+	}
+	if err := VerifyArchive(arch, strings.ToUpper(checksum)); err != nil {
+		t.Errorf("upper case checksum: %v", err)
+	}
+
+	tampered := append([]byte{}, arch...)
+	tampered[len(tampered)/2] ^= 0xff
+	if err := VerifyArchive(tampered, checksum); err == nil || errors.Is(err, ErrNoArchiveChecksum) {
+		t.Errorf("tampered archive: got error %v", err)
+	}
+
+	if err := VerifyArchive(arch, ""); !errors.Is(err, ErrNoArchiveChecksum) {
+		t.Errorf("missing checksum: got error %v, want ErrNoArchiveChecksum", err)
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	newPackage := func(t *testing.T) string {
+		t.Helper()
+		dir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(dir, "bin"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(filepath.Join(dir, "bin", "tool"), []byte("tool"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := createChecksumsFile(dir); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	if err := VerifyChecksums(newPackage(t)); err != nil {
+		t.Errorf("untouched package: %v", err)
+	}
+
+	tests := map[string]func(dir string) error{
+		"changed file": func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "bin", "tool"), []byte("evil"), 0755)
+		},
+		"missing file": func(dir string) error {
+			return os.Remove(filepath.Join(dir, "bin", "tool"))
+		},
+		"added file": func(dir string) error {
+			return os.WriteFile(filepath.Join(dir, "bin", "extra"), nil, 0644)
+		},
+		"tampered checksums": func(dir string) error {
+			path := filepath.Join(dir, ChecksumsFileName)
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			return os.WriteFile(path, []byte(strings.Replace(string(content), `"digest": "`, `"digest": "0`, 1)), 0644)
+		},
+	}
+	for name, tamper := range tests {
+		dir := newPackage(t)
+		if err := tamper(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := VerifyChecksums(dir); err == nil || errors.Is(err, ErrNoChecksums) {
+			t.Errorf("%s: got error %v", name, err)
+		}
+	}
+
+	dir := newPackage(t)
+	if err := os.Remove(filepath.Join(dir, ChecksumsFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyChecksums(dir); !errors.Is(err, ErrNoChecksums) {
+		t.Errorf("missing checksums: got error %v, want ErrNoChecksums", err)
+	}
+}
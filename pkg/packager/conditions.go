@@ -0,0 +1,58 @@
+package packager
+
+import (
+	"runtime"
+)
+
+// Conditions limit when a dependency is installed. They are stored in
+// dependencies.json together with the dependency itself.
+type Conditions struct {
+	// Optional dependencies are skipped when no suitable version is available.
+	Optional bool `json:"optional,omitempty" yaml:"optional,omitempty" toml:"optional,omitempty"`
+	// Dev dependencies are only installed when listed directly in the update
+	// file, never as dependencies of another package.
+	Dev bool `json:"dev,omitempty" yaml:"dev,omitempty" toml:"dev,omitempty"`
+	// OS and Arch restrict the dependency to the listed GOOS and GOARCH values.
+	OS   []string `json:"os,omitempty" yaml:"os,omitempty" toml:"os,omitempty"`
+	Arch []string `json:"arch,omitempty" yaml:"arch,omitempty" toml:"arch,omitempty"`
+	// Labels restrict the dependency to updates run with one of the labels.
+	Labels []string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+}
+
+// matchesPlatform reports whether the OS and Arch conditions accept goos and goarch.
+func (c Conditions) matchesPlatform(goos, goarch string) bool {
+	return (len(c.OS) == 0 || contains(c.OS, goos)) && (len(c.Arch) == 0 || contains(c.Arch, goarch))
+}
+
+func (c Conditions) matchesLabels(labels []string) bool {
+	if len(c.Labels) == 0 {
+		return true
+	}
+	for _, label := range labels {
+		if contains(c.Labels, label) {
+			return true
+		}
+	}
+	return false
+}
+
+// includes reports whether dependency should be installed by this update.
+// root is set for dependencies listed in the update file itself.
+func (c UpdateConfig) includes(dependency Dependency, root bool) bool {
+	if dependency.Dev && (!root || contains(c.Without, "dev")) {
+		return false
+	}
+	if dependency.Optional && contains(c.Without, "optional") {
+		return false
+	}
+	return dependency.matchesPlatform(runtime.GOOS, runtime.GOARCH) && dependency.matchesLabels(c.Labels)
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
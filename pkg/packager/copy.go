@@ -3,54 +3,118 @@ package packager
 import (
 	"fmt"
 	"io"
+	"io/fs"
 	"os"
+	"path"
 	"path/filepath"
 	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+	"github.com/bpva/gopm/pkg/archiver"
 )
 
 type Package struct {
-	Name         string       `json:"name" yaml:"name"`
-	Version      string       `json:"ver" yaml:"ver"`
-	Targets      []Target     `json:"targets,omitempty" yaml:"targets,omitempty"`
-	Dependencies []Dependency `json:"packets,omitempty" yaml:"packets,omitempty"`
+	Name    string `json:"name" yaml:"name" toml:"name"`
+	Version string `json:"ver" yaml:"ver" toml:"ver"`
+	// Vars are the values of ${NAME} references in the name, version,
+	// targets and dependencies of the package file.
+	Vars map[string]string `json:"vars,omitempty" yaml:"vars,omitempty" toml:"vars,omitempty"`
+	// Description, Authors, License, Homepage, Repository and Labels are
+	// copied to metadata.json and shown by info and list.
+	Description  string       `json:"description,omitempty" yaml:"description,omitempty" toml:"description,omitempty"`
+	Authors      []string     `json:"authors,omitempty" yaml:"authors,omitempty" toml:"authors,omitempty"`
+	License      string       `json:"license,omitempty" yaml:"license,omitempty" toml:"license,omitempty"`
+	Homepage     string       `json:"homepage,omitempty" yaml:"homepage,omitempty" toml:"homepage,omitempty"`
+	Repository   string       `json:"repository,omitempty" yaml:"repository,omitempty" toml:"repository,omitempty"`
+	Labels       []string     `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+	Targets      []Target     `json:"targets" yaml:"targets" toml:"targets"`
+	Dependencies []Dependency `json:"packets,omitempty" yaml:"packets,omitempty" toml:"packets,omitempty"`
 }
 
 type Target struct {
-	Path    string `json:"path" yaml:"path"`
-	Exclude string `json:"exclude,omitempty" yaml:"exclude,omitempty"`
+	Path string `json:"path" yaml:"path" toml:"path"`
+	// Exclude leaves matching files out, Include keeps only matching files.
+	Exclude Patterns `json:"exclude,omitempty" yaml:"exclude,omitempty" toml:"exclude,omitempty"`
+	Include Patterns `json:"include,omitempty" yaml:"include,omitempty" toml:"include,omitempty"`
+	// StripPrefix is removed from the path of every match relative to the
+	// manifest directory, Dest is the directory in the package it is placed in.
+	StripPrefix string `json:"strip_prefix,omitempty" yaml:"strip_prefix,omitempty" toml:"strip_prefix,omitempty"`
+	Dest        string `json:"dest,omitempty" yaml:"dest,omitempty" toml:"dest,omitempty"`
 }
 
+// Patterns is a list of file patterns. In manifests it is written either as a
+// list or as a single comma-separated string.
+type Patterns []string
+
 type Dependency struct {
-	Name     string `json:"name" yaml:"name"`
-	Version  string `json:"ver" yaml:"ver"`
-	Operator string `json:"operator" yaml:"operator"`
+	Name       string `json:"name" yaml:"name" toml:"name"`
+	Version    string `json:"ver" yaml:"ver" toml:"ver"`
+	Operator   string `json:"operator" yaml:"operator" toml:"operator"`
+	Conditions `yaml:",inline"`
 }
 
-func copyTargets(targets []Target, packageDir string) error {
+// copyTargets copies the files matched by targets into packageDir keeping
+// their paths relative to baseDir, the directory of the manifest.
+func copyTargets(targets []Target, baseDir, packageDir string, options CreateOptions) error {
+	// copied maps every destination path to the file it was copied from
+	copied := map[string]string{}
+
+	ignore, err := newGopmIgnore(baseDir)
+	if err != nil {
+		return err
+	}
+
 	for _, target := range targets {
-		matches, err := filepath.Glob(target.Path)
+		matches, err := globTarget(baseDir, target.Path)
 		if err != nil {
-			return fmt.Errorf("failed to match pattern '%s': %w", target.Path, err)
+			return err
+		}
+		if len(matches) == 0 {
+			return fmt.Errorf("target '%s' does not match any file", target.Path)
+		}
+		c := copier{
+			excludes: excludeMatcher{
+				baseDir:  baseDir,
+				patterns: target.Exclude,
+				includes: target.Include,
+				ignore:   ignore,
+				verbose:  options.Verbose,
+			},
+			packageDir:  packageDir,
+			copied:      copied,
+			dereference: options.Dereference,
 		}
-		excludes := strings.Split(target.Exclude, ",")
 		for _, match := range matches {
-			fileInfo, err := os.Stat(match)
+			fileInfo, err := c.stat(match)
 			if err != nil {
 				return fmt.Errorf("failed to access path '%s': %w", match, err)
 			}
 
-			if shouldExclude(fileInfo.Name(), excludes) {
+			excluded, err := c.excludes.shouldExclude(match, fileInfo.IsDir())
+			if err != nil {
+				return err
+			}
+			if excluded {
 				continue
 			}
 			// pakageDir: /gopm_packages/<package-name>/<package-version>/
-			destPath := getDestinationPath(packageDir, match)
-			if fileInfo.IsDir() {
-				err = copyDir(match, destPath, excludes)
+			destPath, err := getDestinationPath(packageDir, baseDir, match, target)
+			if err != nil {
+				return err
+			}
+			switch {
+			case fileInfo.Mode()&os.ModeSymlink != 0:
+				err = c.copySymlink(match, destPath)
+				if err != nil {
+					return fmt.Errorf("failed to copy symlink '%s' to '%s': %w", match, destPath, err)
+				}
+			case fileInfo.IsDir():
+				err = c.copyDir(match, destPath)
 				if err != nil {
 					return fmt.Errorf("failed to copy directory '%s' to '%s': %w", match, destPath, err)
 				}
-			} else {
-				err = copyFile(match, destPath, excludes)
+			default:
+				err = c.copyFile(match, destPath)
 				if err != nil {
 					return fmt.Errorf("failed to copy file '%s' to '%s': %w", match, destPath, err)
 				}
@@ -60,18 +124,151 @@ func copyTargets(targets []Target, packageDir string) error {
 
 	return nil
 }
-func getDestinationPath(packageDir, filePath string) string {
-	// to fix
-	baseDir := filepath.Base(packageDir)
-	fileName := filepath.Base(filePath)
 
-	destinationPath := filepath.Join(filepath.Dir(packageDir), baseDir, fileName)
+// globTarget returns the files matched by the path of a target. Relative
+// paths are matched in baseDir, the directory of the manifest, whatever the
+// current directory is.
+func globTarget(baseDir, pattern string) ([]string, error) {
+	if filepath.IsAbs(pattern) {
+		matches, err := doublestar.FilepathGlob(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("failed to match pattern '%s': %w", pattern, err)
+		}
+		return matches, nil
+	}
+
+	relPattern := path.Clean(filepath.ToSlash(pattern))
+	if !fs.ValidPath(relPattern) {
+		// A target leading out of the manifest directory, e.g. ../shared/*
+		matches, err := doublestar.FilepathGlob(filepath.Join(baseDir, pattern))
+		if err != nil {
+			return nil, fmt.Errorf("failed to match pattern '%s': %w", pattern, err)
+		}
+		return matches, nil
+	}
+	matches, err := doublestar.Glob(os.DirFS(baseDir), relPattern)
+	if err != nil {
+		return nil, fmt.Errorf("failed to match pattern '%s': %w", pattern, err)
+	}
+	for i, match := range matches {
+		matches[i] = filepath.Join(baseDir, filepath.FromSlash(match))
+	}
+	return matches, nil
+}
+
+// getDestinationPath returns where filePath is placed in the package: its path
+// relative to baseDir, without the target's strip prefix, inside the target's
+// dest directory. An absolute strip prefix is removed from the absolute path
+// of the file instead. What is left after the strip prefix must not lead out
+// of the package.
+func getDestinationPath(packageDir, baseDir, filePath string, target Target) (string, error) {
+	absBaseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path of '%s': %w", baseDir, err)
+	}
+	absFilePath, err := filepath.Abs(filePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get absolute path of '%s': %w", filePath, err)
+	}
+	relPath, err := filepath.Rel(absBaseDir, absFilePath)
+	if err != nil {
+		return "", fmt.Errorf("failed to get path of '%s' relative to '%s': %w", filePath, baseDir, err)
+	}
+
+	if target.StripPrefix != "" {
+		prefix := filepath.Clean(target.StripPrefix)
+		strippedPath := relPath
+		if filepath.IsAbs(prefix) {
+			strippedPath = absFilePath
+		}
+		if strippedPath == prefix {
+			relPath = "."
+		} else if strings.HasPrefix(strippedPath, prefix+string(filepath.Separator)) {
+			relPath = strings.TrimPrefix(strippedPath, prefix+string(filepath.Separator))
+		} else {
+			return "", fmt.Errorf("path '%s' does not start with strip_prefix '%s'", strippedPath, target.StripPrefix)
+		}
+	}
+	if !isLocalPath(relPath) {
+		return "", fmt.Errorf("path '%s' is outside of the manifest directory '%s', use strip_prefix and dest to place it in the package", filePath, baseDir)
+	}
+
+	destPath := filepath.Join(target.Dest, relPath)
+	if !isLocalPath(destPath) {
+		return "", fmt.Errorf("dest '%s' of target '%s' points outside of the package", target.Dest, target.Path)
+	}
+
+	return filepath.Join(packageDir, destPath), nil
+}
+
+// isLocalPath reports whether the relative path stays inside its base directory.
+func isLocalPath(relPath string) bool {
+	relPath = filepath.Clean(relPath)
+	return !filepath.IsAbs(relPath) && relPath != ".." && !strings.HasPrefix(relPath, ".."+string(filepath.Separator))
+}
 
-	return destinationPath
+// excludeMatcher decides which files of a target are left out of the package.
+// Patterns without a slash match the name of a file or directory at any depth,
+// patterns with a slash match its path relative to baseDir. Both may use **
+// to match any number of directories. Files not matching any of the include
+// patterns, if there are some, and files ignored by .gopmignore are excluded
+// as well. Every excluded path is printed when verbose is set.
+type excludeMatcher struct {
+	baseDir  string
+	patterns []string
+	includes []string
+	ignore   *gopmIgnore
+	verbose  bool
 }
-func shouldExclude(fileName string, exclude []string) bool {
-	for _, excludedPattern := range exclude {
-		matched, err := filepath.Match(excludedPattern, fileName)
+
+func (m excludeMatcher) shouldExclude(path string, isDir bool) (bool, error) {
+	excluded, err := m.matches(path, isDir)
+	if err != nil {
+		return false, err
+	}
+	if excluded && m.verbose {
+		fmt.Printf("Excluding %s\n", path)
+	}
+	return excluded, nil
+}
+
+func (m excludeMatcher) matches(path string, isDir bool) (bool, error) {
+	relPath := filepath.ToSlash(filepath.Clean(path))
+	absBaseDir, err := filepath.Abs(m.baseDir)
+	if err == nil {
+		if absPath, err := filepath.Abs(path); err == nil {
+			if rel, err := filepath.Rel(absBaseDir, absPath); err == nil && isLocalPath(rel) {
+				relPath = filepath.ToSlash(rel)
+			}
+		}
+	}
+	name := filepath.Base(path)
+
+	if matchesAny(m.patterns, name, relPath) {
+		return true, nil
+	}
+	// Directories are always walked, include patterns only select files
+	if !isDir && len(m.includes) > 0 && !matchesAny(m.includes, name, relPath) {
+		return true, nil
+	}
+
+	if m.ignore == nil {
+		return false, nil
+	}
+	return m.ignore.ignored(path, isDir)
+}
+
+func matchesAny(patterns []string, name, relPath string) bool {
+	for _, pattern := range patterns {
+		if pattern == "" {
+			continue
+		}
+		subject := name
+		if strings.Contains(pattern, "/") {
+			subject = relPath
+			pattern = strings.TrimPrefix(pattern, "./")
+		}
+		matched, err := doublestar.Match(pattern, subject)
 		if err != nil {
 			continue
 		}
@@ -82,14 +279,49 @@ func shouldExclude(fileName string, exclude []string) bool {
 	return false
 }
 
-func copyFile(srcPath, destPath string, excludes []string) error {
+// copier copies the files of one target into the package, keeping file
+// modes and modification times. Symlinks are copied as links unless
+// dereference is set.
+type copier struct {
+	excludes    excludeMatcher
+	packageDir  string
+	copied      map[string]string
+	dereference bool
+	// parents are the directories being copied, from the outermost down,
+	// to detect symlinks leading back into one of them
+	parents []os.FileInfo
+}
+
+func (c *copier) stat(path string) (os.FileInfo, error) {
+	if c.dereference {
+		return os.Stat(path)
+	}
+	return os.Lstat(path)
+}
+
+// claim records that srcPath is copied to destPath. Two different files must
+// not end up at the same place in the package.
+func (c *copier) claim(srcPath, destPath string) error {
+	if previous, ok := c.copied[destPath]; ok && previous != filepath.Clean(srcPath) {
+		return fmt.Errorf("'%s' and '%s' are both copied to '%s'", previous, srcPath, destPath)
+	}
+	c.copied[destPath] = filepath.Clean(srcPath)
+	return nil
+}
+
+func (c *copier) copyFile(srcPath, destPath string) error {
 	srcFileInfo, err := os.Stat(srcPath)
 	if err != nil {
 		return err
 	}
 
 	if srcFileInfo.IsDir() {
-		return copyDir(srcPath, destPath, excludes)
+		return c.copyDir(srcPath, destPath)
+	}
+
+	err = c.claim(srcPath, destPath)
+	if err != nil {
+		return err
 	}
 
 	srcFile, err := os.Open(srcPath)
@@ -104,7 +336,7 @@ func copyFile(srcPath, destPath string, excludes []string) error {
 		return err
 	}
 
-	destFile, err := os.Create(destPath)
+	destFile, err := os.OpenFile(destPath, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, srcFileInfo.Mode().Perm())
 	if err != nil {
 		return err
 	}
@@ -115,10 +347,57 @@ func copyFile(srcPath, destPath string, excludes []string) error {
 		return err
 	}
 
-	return nil
+	return preserveAttributes(destPath, srcFileInfo)
+}
+
+func (c *copier) copySymlink(srcPath, destPath string) error {
+	err := c.claim(srcPath, destPath)
+	if err != nil {
+		return err
+	}
+
+	linkTarget, err := os.Readlink(srcPath)
+	if err != nil {
+		return err
+	}
+
+	// The archive would be refused on upload and install, see archiver.CheckLink
+	name, err := filepath.Rel(c.packageDir, destPath)
+	if err != nil {
+		return err
+	}
+	if archiver.CheckLink(filepath.ToSlash(name), linkTarget) != nil {
+		return fmt.Errorf("symlink '%s' points to '%s' outside of the package, use -dereference to copy the file it points to", srcPath, linkTarget)
+	}
+
+	err = os.MkdirAll(filepath.Dir(destPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+	err = os.Remove(destPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return os.Symlink(linkTarget, destPath)
 }
 
-func copyDir(srcDir, destDir string, excludes []string) error {
+func (c *copier) copyDir(srcDir, destDir string) error {
+	srcDirInfo, err := os.Stat(srcDir)
+	if err != nil {
+		return err
+	}
+
+	// A dereferenced symlink to a parent directory would be copied forever.
+	// os.SameFile compares device and inode, whatever path led here.
+	for _, parent := range c.parents {
+		if os.SameFile(parent, srcDirInfo) {
+			return fmt.Errorf("symlink loop: '%s' leads back to one of its parent directories", srcDir)
+		}
+	}
+	c.parents = append(c.parents, srcDirInfo)
+	defer func() { c.parents = c.parents[:len(c.parents)-1] }()
+
 	// Create the destination directory
 	if _, err := os.Stat(destDir); os.IsNotExist(err) {
 		err = os.MkdirAll(destDir, 0755)
@@ -137,21 +416,41 @@ func copyDir(srcDir, destDir string, excludes []string) error {
 		srcPath := filepath.Join(srcDir, fileInfo.Name())
 		destPath := filepath.Join(destDir, fileInfo.Name())
 
-		if fileInfo.IsDir() {
-			err = copyDir(srcPath, destPath, excludes)
-			if err != nil {
-				return err
-			}
-		} else {
-			if shouldExclude(fileInfo.Name(), excludes) {
-				continue
-			}
-			err = copyFile(srcPath, destPath, excludes)
-			if err != nil {
-				return err
-			}
+		info, err := c.stat(srcPath)
+		if err != nil {
+			return err
+		}
+
+		excluded, err := c.excludes.shouldExclude(srcPath, info.IsDir())
+		if err != nil {
+			return err
+		}
+		if excluded {
+			continue
+		}
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			err = c.copySymlink(srcPath, destPath)
+		case info.IsDir():
+			err = c.copyDir(srcPath, destPath)
+		default:
+			err = c.copyFile(srcPath, destPath)
+		}
+		if err != nil {
+			return err
 		}
 	}
 
-	return nil
+	// Set the directory attributes last, copying its contents changes the mtime
+	return preserveAttributes(destDir, srcDirInfo)
+}
+
+// preserveAttributes applies the permissions and modification time of info
+// to path regardless of the umask.
+func preserveAttributes(path string, info os.FileInfo) error {
+	err := os.Chmod(path, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	return os.Chtimes(path, info.ModTime(), info.ModTime())
 }
@@ -0,0 +1,266 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bpva/gopm/pkg/archiver"
+)
+
+// chdir changes the working directory for the rest of the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
+
+func TestTargetsAreRelativeToTheManifest(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(baseDir, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(baseDir, "bin", "tool"), []byte("x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// the working directory has a bin directory of its own that must not match
+	wd := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(wd, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(wd, "bin", "other"), []byte("y"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, wd)
+
+	packageDir := t.TempDir()
+	targets := []Target{{Path: "./bin/*"}}
+	if err := copyTargets(targets, baseDir, packageDir, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(packageDir, "bin", "tool")); err != nil {
+		t.Errorf("target in the manifest directory was not copied: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(packageDir, "bin", "other")); err == nil {
+		t.Error("target was matched in the working directory")
+	}
+
+	err := copyTargets([]Target{{Path: "../*"}}, baseDir, t.TempDir(), CreateOptions{})
+	if err == nil {
+		t.Error("target outside of the manifest directory was accepted")
+	}
+}
+
+// TestTargetsOutsideTheManifest checks that files outside of the manifest
+// directory can be packaged once strip_prefix and dest place them inside the
+// package.
+func TestTargetsOutsideTheManifest(t *testing.T) {
+	root := t.TempDir()
+	baseDir := filepath.Join(root, "project")
+	outside := filepath.Join(root, "outside")
+	if err := os.MkdirAll(baseDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(outside, "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(outside, "bin", "tool"), []byte("x"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		target Target
+		want   string
+	}{
+		{Target{Path: filepath.Join(outside, "bin", "*"), StripPrefix: outside, Dest: "usr"}, "usr/bin/tool"},
+		{Target{Path: filepath.Join(outside, "bin", "*"), StripPrefix: filepath.Join(outside, "bin"), Dest: "bin"}, "bin/tool"},
+		{Target{Path: "../outside/bin/*", StripPrefix: "../outside"}, "bin/tool"},
+	}
+	for _, test := range tests {
+		packageDir := t.TempDir()
+		if err := copyTargets([]Target{test.target}, baseDir, packageDir, CreateOptions{}); err != nil {
+			t.Errorf("%+v: %v", test.target, err)
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(packageDir, filepath.FromSlash(test.want))); err != nil {
+			t.Errorf("%+v: %v", test.target, err)
+		}
+	}
+
+	rejected := []Target{
+		{Path: filepath.Join(outside, "bin", "*")},
+		// dest cannot bring a path leading out of the package back in
+		{Path: "../outside/bin/*", Dest: "bin"},
+	}
+	for _, target := range rejected {
+		err := copyTargets([]Target{target}, baseDir, t.TempDir(), CreateOptions{})
+		if err == nil || !strings.Contains(err.Error(), "outside of the manifest directory") {
+			t.Errorf("%+v: got error %v", target, err)
+		}
+	}
+}
+
+func TestDereferencedSymlinkLoop(t *testing.T) {
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(baseDir, "a", "b"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("..", filepath.Join(baseDir, "a", "b", "loop")); err != nil {
+		t.Fatal(err)
+	}
+	targets := []Target{{Path: "./a"}}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- copyTargets(targets, baseDir, t.TempDir(), CreateOptions{Dereference: true})
+	}()
+	select {
+	case err := <-done:
+		if err == nil || !strings.Contains(err.Error(), "symlink loop") {
+			t.Errorf("got error %v, want a symlink loop", err)
+		}
+	case <-time.After(10 * time.Second):
+		t.Fatal("copying a symlink loop did not terminate")
+	}
+
+	packageDir := t.TempDir()
+	if err := copyTargets(targets, baseDir, packageDir, CreateOptions{}); err != nil {
+		t.Fatalf("without dereference: %v", err)
+	}
+	if linkname, err := os.Readlink(filepath.Join(packageDir, "a", "b", "loop")); err != nil || linkname != ".." {
+		t.Errorf("without dereference the link was copied as %q, %v", linkname, err)
+	}
+}
+
+func TestUnsafeSymlinks(t *testing.T) {
+	for _, linkname := range []string{"/usr/bin/env", "../../outside", "../.."} {
+		baseDir := t.TempDir()
+		if err := os.MkdirAll(filepath.Join(baseDir, "bin"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Symlink(linkname, filepath.Join(baseDir, "bin", "link")); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, targets := range [][]Target{{{Path: "./bin"}}, {{Path: "./bin/*"}}} {
+			err := copyTargets(targets, baseDir, t.TempDir(), CreateOptions{})
+			if err == nil || !strings.Contains(err.Error(), "link' points to '"+linkname+"' outside of the package") || !strings.Contains(err.Error(), "-dereference") {
+				t.Errorf("%s in %s: got error %v", linkname, targets[0].Path, err)
+			}
+		}
+	}
+
+	// Links within the package are kept, also when the target is moved
+	baseDir := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(baseDir, "build", "bin"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../share", filepath.Join(baseDir, "build", "bin", "link")); err != nil {
+		t.Fatal(err)
+	}
+	targets := []Target{{Path: "./build/bin", StripPrefix: "build"}}
+	if err := copyTargets(targets, baseDir, t.TempDir(), CreateOptions{}); err != nil {
+		t.Errorf("link inside the package: %v", err)
+	}
+	targets = []Target{{Path: "./build/bin", StripPrefix: "build/bin"}}
+	if err := copyTargets(targets, baseDir, t.TempDir(), CreateOptions{}); err == nil {
+		t.Error("link leaving the package after strip_prefix was accepted")
+	}
+}
+
+// TestPackRoundTrip checks that modes, symlinks and modification times of the
+// targets survive packing and extracting in every archive format.
+func TestPackRoundTrip(t *testing.T) {
+	baseDir := t.TempDir()
+	modTime := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	files := map[string]os.FileMode{
+		"share/tool":          0755,
+		"share/private":       0600,
+		"share/doc/README.md": 0644,
+	}
+	dirs := map[string]os.FileMode{
+		"share":     0750,
+		"share/doc": 0700,
+	}
+	for name, mode := range files {
+		path := filepath.Join(baseDir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(name), mode); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chmod(path, mode); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink("doc/README.md", filepath.Join(baseDir, "share", "readme")); err != nil {
+		t.Fatal(err)
+	}
+	for name := range files {
+		if err := os.Chtimes(filepath.Join(baseDir, filepath.FromSlash(name)), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"share/doc", "share"} {
+		path := filepath.Join(baseDir, filepath.FromSlash(name))
+		if err := os.Chmod(path, dirs[name]); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	packageDir := t.TempDir()
+	if err := copyTargets([]Target{{Path: "./share"}}, baseDir, packageDir, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, format := range []archiver.Format{archiver.FormatZip, archiver.FormatTarGz, archiver.FormatTarZst} {
+		arch, err := archiver.ArchiveWithOptions(packageDir, archiver.Options{Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+		destination := t.TempDir()
+		if err := archiver.Extract(arch, destination, archiver.ExtractOptions{}); err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+
+		check := func(name string, want os.FileMode) {
+			info, err := os.Lstat(filepath.Join(destination, filepath.FromSlash(name)))
+			if err != nil {
+				t.Errorf("%s: %v", format, err)
+				return
+			}
+			if info.Mode() != want {
+				t.Errorf("%s: %s has mode %v, want %v", format, name, info.Mode(), want)
+			}
+			if !info.ModTime().Equal(modTime) {
+				t.Errorf("%s: %s has mtime %v, want %v", format, name, info.ModTime(), modTime)
+			}
+		}
+		for name, mode := range files {
+			check(name, mode)
+		}
+		for name, mode := range dirs {
+			check(name, os.ModeDir|mode)
+		}
+		if linkname, err := os.Readlink(filepath.Join(destination, "share", "readme")); err != nil || linkname != "doc/README.md" {
+			t.Errorf("%s: symlink points to %q, %v", format, linkname, err)
+		}
+	}
+}
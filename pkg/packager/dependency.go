@@ -34,31 +34,12 @@ func checkDependency(dependency Dependency) error {
 		return fmt.Errorf("invalid version for dependency %s: %w", dependency.Name, err)
 	}
 
+	if !contains(operators, dependency.Operator) {
+		return fmt.Errorf("invalid operator for dependency %s: %s", dependency.Name, dependency.Operator)
+	}
 	for _, installedVersion := range installedVersions {
-		// Use semver.Compare to compare the installed version with the required version based on the operator
-		switch dependency.Operator {
-		case "==", "=":
-			if installedVersion.Equal(requiredVersion) {
-				return nil
-			}
-		case ">=":
-			if installedVersion.Compare(requiredVersion) >= 0 {
-				return nil
-			}
-		case "<=":
-			if installedVersion.Compare(requiredVersion) <= 0 {
-				return nil
-			}
-		case ">":
-			if installedVersion.Compare(requiredVersion) > 0 {
-				return nil
-			}
-		case "<":
-			if installedVersion.Compare(requiredVersion) < 0 {
-				return nil
-			}
-		default:
-			return fmt.Errorf("invalid operator for dependency %s: %s", dependency.Name, dependency.Operator)
+		if compareVersions(installedVersion, dependency.Operator, requiredVersion) {
+			return nil
 		}
 	}
 
@@ -86,166 +67,89 @@ func getInstalledVersions(dependencyDir string) ([]*semver.Version, error) {
 		return nil, fmt.Errorf("failed to read dependency directory: %w", err)
 	}
 
+	names := []string{}
 	for _, file := range files {
 		if file.IsDir() {
-			version, err := semver.NewVersion(file.Name())
-			if err == nil {
-				installedVersions = append(installedVersions, version)
-			}
+			names = append(names, file.Name())
 		}
 	}
+	versions, _ := parseVersions(names)
+	installedVersions = append(installedVersions, versions...)
 
 	return installedVersions, nil
 }
 
-func CollectDependencies(updateConfig *UpdateConfig, sshClient *ssh.Client) error {
-	for _, update := range updateConfig.Updates {
-		session, err := sshClient.NewSession()
+// fetchDependencies reads dependencies.json of the given package version on
+// the remote server, or in the local dir when sshClient is nil.
+func fetchDependencies(dependencyDir, version string, sshClient *ssh.Client) ([]Dependency, error) {
+	dependenciesFilePath := filepath.Join(dependencyDir, version, "dependencies.json")
+
+	var output []byte
+	var err error
+	if sshClient != nil {
+		command := fmt.Sprintf("cat %s", dependenciesFilePath)
+		execSession, err := sshClient.NewSession()
 		if err != nil {
-			return fmt.Errorf("failed to create SSH session: %w", err)
+			return nil, fmt.Errorf("failed to create SSH session for command execution: %w", err)
 		}
-
-		dependencyDir := filepath.Join("gopm_packages", update.Name)
-		versions, err := FindSuitableVersions(dependencyDir, update.Version, update.Operator, sshClient)
+		output, err = execSession.CombinedOutput(command)
+		execSession.Close()
 		if err != nil {
-			session.Close()
-			return fmt.Errorf("failed to find suitable versions: %w", err)
+			return nil, fmt.Errorf("failed to execute SSH command %s: %w. Please ensure that the package exists", command, err)
 		}
-
-		if len(versions) > 0 {
-			// Use the greatest version suitable
-			suitableVersion := versions[0]
-
-			dependenciesFilePath := filepath.Join(dependencyDir, suitableVersion, "dependencies.json")
-
-			command := fmt.Sprintf("cat %s", dependenciesFilePath)
-
-			execSession, err := sshClient.NewSession()
-			if err != nil {
-				session.Close()
-				return fmt.Errorf("failed to create SSH session for command execution: %w", err)
-			}
-
-			output, err := execSession.CombinedOutput(command)
-			execSession.Close() // Close the execSession
-			session.Close()
-
-			if err != nil {
-				return fmt.Errorf("failed to execute SSH command %s: %w. Please ensure that the package exists", command, err)
-			}
-
-			var dependencies []Dependency
-			err = json.Unmarshal([]byte(strings.TrimSpace(string(output))), &dependencies)
-			if err != nil {
-				return fmt.Errorf("failed to parse dependencies JSON: %w", err)
-			}
-
-			err = addDependencies(updateConfig, dependencies, sshClient)
-			if err != nil {
-				return fmt.Errorf("failed to add dependencies: %w", err)
-			}
-		} else {
-			session.Close()
+	} else {
+		output, err = os.ReadFile(dependenciesFilePath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read dependencies file: %w", err)
 		}
 	}
 
-	return nil
-}
-
-func addDependencies(updateConfig *UpdateConfig, dependencies []Dependency, sshClient *ssh.Client) error {
-	session, err := sshClient.NewSession()
+	dependencies, err := parseDependencies([]byte(strings.TrimSpace(string(output))))
 	if err != nil {
-		return fmt.Errorf("failed to create SSH session: %w", err)
+		return nil, fmt.Errorf("failed to parse dependencies JSON: %w", err)
 	}
-	defer session.Close()
-
-	for len(dependencies) > 0 {
-		dependency := dependencies[0]
-		dependencies = dependencies[1:]
-
-		found := false
-
-		for _, update := range updateConfig.Updates {
-			if update.Name == dependency.Name {
-				if satisfiesOperator(update.Version, update.Operator, dependency.Version) {
-					found = true
-					break
-				}
-			}
-		}
 
-		if !found {
-			newDependency := Dependency{
-				Name:     dependency.Name,
-				Version:  dependency.Version,
-				Operator: dependency.Operator,
-			}
-			updateConfig.Updates = append(updateConfig.Updates, newDependency)
+	return dependencies, nil
+}
 
-			dependencyDir := filepath.Join("gopm_packages", dependency.Name)
-			versions, err := FindSuitableVersions(dependencyDir, dependency.Version, dependency.Operator, sshClient)
-			if err != nil {
-				return fmt.Errorf("failed to find suitable versions: %w", err)
-			}
+// parseDependencies decodes a dependencies.json of a published package.
+// Unlike package files it may have been written by a newer version of gopm,
+// so fields this version does not know are ignored.
+func parseDependencies(data []byte) ([]Dependency, error) {
+	var stored []dependencyFields
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
 
-			if len(versions) > 0 {
-				// Use the greatest version
-				suitableVersion := versions[0]
-
-				dependenciesFilePath := filepath.Join(dependencyDir, suitableVersion, "dependencies.json")
-
-				command := fmt.Sprintf("cat %s", dependenciesFilePath)
-				execSession, err := sshClient.NewSession()
-				if err != nil {
-					session.Close()
-					return fmt.Errorf("failed to create SSH session for command execution: %w", err)
-				}
-				output, err := execSession.CombinedOutput(command)
-				execSession.Close()
-				session.Close()
-				if err != nil {
-					return fmt.Errorf("failed to execute SSH command %s: %w. Please ensure that package exists", command, err)
-				}
-
-				var nestedDependencies []Dependency
-				err = json.Unmarshal([]byte(strings.TrimSpace(string(output))), &nestedDependencies)
-				if err != nil {
-					return fmt.Errorf("failed to parse dependencies JSON: %w", err)
-				}
-
-				// Check if all nested dependencies are already found
-
-				allFound := true
-				for _, nestedDependency := range nestedDependencies {
-					nestedFound := false
-					for _, update := range updateConfig.Updates {
-						if update.Name == nestedDependency.Name && satisfiesOperator(update.Version, update.Operator, nestedDependency.Version) {
-							nestedFound = true
-							break
-						}
-					}
-					if !nestedFound {
-						allFound = false
-						dependencies = append(dependencies, nestedDependency)
-					}
-				}
-				if !allFound {
-					continue
-				}
-			}
+	dependencies := make([]Dependency, len(stored))
+	for i, fields := range stored {
+		if err := dependencies[i].setFields(fields); err != nil {
+			return nil, err
 		}
 	}
-
-	return nil
+	return dependencies, nil
 }
 
-func FindSuitableVersions(dir, targetVersion, operator string, sshClient *ssh.Client) ([]string, error) {
-	versions := []string{}
+// FindSuitableVersions lists the versions of the package in dir that satisfy
+// the operator and target version, greatest first. The names of version
+// directories that are not valid semantic versions are ignored and returned
+// as invalid.
+//
+// Pre-release versions are skipped unless allowPrerelease is set. A
+// pre-release target version only allows the pre-releases of the same
+// major.minor.patch, as npm and the go command do: >=2.0.0-rc1 selects
+// 2.0.0-rc2 but not 2.1.0-beta. Build metadata does not take part in matching
+// or ordering; when two versions differ only in metadata the one without
+// metadata comes first, otherwise the metadata is compared as a string so the
+// order is stable.
+func FindSuitableVersions(dir, targetVersion, operator string, allowPrerelease bool, sshClient *ssh.Client) (versions []string, invalid []string, err error) {
+	versions = []string{}
+	names := []string{}
 
 	if sshClient != nil {
 		session, err := sshClient.NewSession()
 		if err != nil {
-			return versions, fmt.Errorf("failed to create SSH session: %w", err)
+			return versions, nil, fmt.Errorf("failed to create SSH session: %w", err)
 		}
 		defer session.Close()
 
@@ -255,45 +159,87 @@ func FindSuitableVersions(dir, targetVersion, operator string, sshClient *ssh.Cl
 		// Execute the remote command
 		output, err := session.CombinedOutput(command)
 		if err != nil {
-			return versions, fmt.Errorf("failed to execute SSH command %s: %w", command, err)
+			return versions, nil, fmt.Errorf("failed to execute SSH command %s: %w", command, err)
 		}
 
 		// Split the output into individual lines
-		lines := strings.Split(strings.TrimSpace(string(output)), "\n")
-
-		// Iterate over each line and check if it satisfies the version requirements
-		for _, line := range lines {
-			if satisfiesOperator(line, operator, targetVersion) {
-				versions = append(versions, line)
-			}
-		}
+		names = strings.Split(strings.TrimSpace(string(output)), "\n")
 	} else {
 		// Read the directory contents locally
 		files, err := os.ReadDir(dir)
 		if err != nil {
-			return versions, fmt.Errorf("failed to read directory: %w", err)
+			return versions, nil, fmt.Errorf("failed to read directory: %w", err)
 		}
 
 		// Iterate over each file and check if it is a directory
 		for _, file := range files {
 			if file.IsDir() {
-				version := file.Name()
-
-				if satisfiesOperator(version, operator, targetVersion) {
-					versions = append(versions, version)
-				}
+				names = append(names, file.Name())
 			}
 		}
 	}
 
+	target, err := semver.NewVersion(targetVersion)
+	if err != nil {
+		return versions, nil, fmt.Errorf("invalid target version %s: %w", targetVersion, err)
+	}
+
+	// Check if every version satisfies the version requirements
+	parsed, invalid := parseVersions(names)
+	suitable := []*semver.Version{}
+	for _, version := range parsed {
+		if version.Prerelease() != "" && !allowPrerelease && !samePatch(version, target) {
+			continue
+		}
+		if compareVersions(version, operator, target) {
+			suitable = append(suitable, version)
+		}
+	}
+
 	// Sort the versions in descending order
-	sort.Slice(versions, func(i, j int) bool {
-		v1, _ := semver.NewVersion(versions[i])
-		v2, _ := semver.NewVersion(versions[j])
-		return v1.GreaterThan(v2)
+	sort.Slice(suitable, func(i, j int) bool {
+		v1, v2 := suitable[i], suitable[j]
+		if !v1.Equal(v2) {
+			return v1.GreaterThan(v2)
+		}
+		if v1.Metadata() == "" || v2.Metadata() == "" {
+			return v1.Metadata() == ""
+		}
+		return v1.Metadata() > v2.Metadata()
 	})
 
-	return versions, nil
+	for _, version := range suitable {
+		versions = append(versions, version.Original())
+	}
+
+	return versions, invalid, nil
+}
+
+// samePatch reports whether version has the major.minor.patch of a
+// pre-release target.
+func samePatch(version, target *semver.Version) bool {
+	return target.Prerelease() != "" &&
+		version.Major() == target.Major() &&
+		version.Minor() == target.Minor() &&
+		version.Patch() == target.Patch()
+}
+
+// parseVersions parses version directory names. Names that are not valid
+// semantic versions are returned as invalid.
+func parseVersions(names []string) (versions []*semver.Version, invalid []string) {
+	versions = []*semver.Version{}
+	for _, name := range names {
+		if name == "" {
+			continue
+		}
+		version, err := semver.NewVersion(name)
+		if err != nil {
+			invalid = append(invalid, name)
+			continue
+		}
+		versions = append(versions, version)
+	}
+	return versions, invalid
 }
 
 func satisfiesOperator(version, operator, targetVersion string) bool {
@@ -307,8 +253,14 @@ func satisfiesOperator(version, operator, targetVersion string) bool {
 		return false
 	}
 
+	return compareVersions(v, operator, t)
+}
+
+// compareVersions reports whether v satisfies the operator and t. A version
+// without an operator and "=" both mean exactly that version, like "==".
+func compareVersions(v *semver.Version, operator string, t *semver.Version) bool {
 	switch operator {
-	case "==":
+	case "==", "=", "":
 		return v.Equal(t)
 	case "<":
 		return v.LessThan(t)
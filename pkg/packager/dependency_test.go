@@ -0,0 +1,125 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// versionDir creates a package directory with a subdirectory for every
+// version, the layout FindSuitableVersions reads.
+func versionDir(t *testing.T, versions ...string) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, version := range versions {
+		if err := os.Mkdir(filepath.Join(dir, version), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestFindSuitableVersionsOperators(t *testing.T) {
+	dir := versionDir(t, "1.0.0", "1.2.0", "2.0.0")
+
+	tests := map[string][]string{
+		"":   {"1.2.0"},
+		"=":  {"1.2.0"},
+		"==": {"1.2.0"},
+		">":  {"2.0.0"},
+		">=": {"2.0.0", "1.2.0"},
+		"<":  {"1.0.0"},
+		"<=": {"1.2.0", "1.0.0"},
+	}
+	for operator, want := range tests {
+		got, _, err := FindSuitableVersions(dir, "1.2.0", operator, false, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("operator %q: got %q, want %q", operator, got, want)
+		}
+	}
+}
+
+func TestFindSuitableVersionsPrerelease(t *testing.T) {
+	dir := versionDir(t, "1.0.0", "2.0.0-rc1", "2.0.0-rc2", "2.1.0-beta", "2.1.0")
+
+	tests := []struct {
+		operator, target string
+		allowPrerelease  bool
+		want             []string
+	}{
+		{">=", "1.0.0", false, []string{"2.1.0", "1.0.0"}},
+		{">=", "1.0.0", true, []string{"2.1.0", "2.1.0-beta", "2.0.0-rc2", "2.0.0-rc1", "1.0.0"}},
+		// a pre-release target allows the pre-releases of its own version only
+		{">=", "2.0.0-rc1", false, []string{"2.1.0", "2.0.0-rc2", "2.0.0-rc1"}},
+		{"<", "2.1.0-beta", false, []string{"1.0.0"}},
+		{"==", "2.1.0-beta", false, []string{"2.1.0-beta"}},
+	}
+	for _, test := range tests {
+		got, _, err := FindSuitableVersions(dir, test.target, test.operator, test.allowPrerelease, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s%s, pre %v: got %q, want %q", test.operator, test.target, test.allowPrerelease, got, test.want)
+		}
+	}
+}
+
+func TestFindSuitableVersionsMetadata(t *testing.T) {
+	dir := versionDir(t, "1.0.0+b", "1.0.0", "1.0.0+a", "0.9.0+z")
+
+	got, _, err := FindSuitableVersions(dir, "0.1.0", ">=", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.0", "1.0.0+b", "1.0.0+a", "0.9.0+z"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	got, _, err = FindSuitableVersions(dir, "1.0.0+other", "==", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.0.0", "1.0.0+b", "1.0.0+a"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("metadata of the target: got %q, want %q", got, want)
+	}
+}
+
+func TestFindSuitableVersionsInvalidNames(t *testing.T) {
+	dir := versionDir(t, "1.0.0", "latest", "1.x", "2.0.0")
+
+	got, invalid, err := FindSuitableVersions(dir, "1.0.0", ">=", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"2.0.0", "1.0.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if want := []string{"1.x", "latest"}; !reflect.DeepEqual(invalid, want) {
+		t.Errorf("got invalid names %q, want %q", invalid, want)
+	}
+
+	if _, _, err := FindSuitableVersions(dir, "latest", ">=", false, nil); err == nil {
+		t.Error("invalid target version was accepted")
+	}
+}
+
+func TestParseDependenciesIgnoresUnknownFields(t *testing.T) {
+	data := []byte(`[{"name": "q", "ver": ">=1.0.0", "optional": true, "added_later": {"x": 1}}]`)
+	got, err := parseDependencies(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Dependency{{Name: "q", Version: "1.0.0", Operator: ">=", Conditions: Conditions{Optional: true}}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+
+	if _, err := parseDependencies([]byte(`[{"ver": "1.0.0"}]`)); err == nil {
+		t.Error("dependency without a name was accepted")
+	}
+}
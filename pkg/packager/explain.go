@@ -0,0 +1,208 @@
+package packager
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// DependencyStep is one edge of a dependency path: the constraint a package
+// is required with and the version update selects for that constraint.
+type DependencyStep struct {
+	Dependency
+	Selected string
+}
+
+// DependencyPath is a chain of dependencies from the update file to a package.
+type DependencyPath []DependencyStep
+
+func (p DependencyPath) String() string {
+	steps := make([]string, 0, len(p))
+	for _, step := range p {
+		steps = append(steps, fmt.Sprintf("%s %s%s (%s)", step.Name, step.Operator, step.Version, step.Selected))
+	}
+	return strings.Join(steps, " -> ")
+}
+
+// dependencyWalker walks the remote dependency graph. Packages listed in
+// pinned always resolve to the pinned version; the others resolve to the
+// version chosen by resolve, or to the greatest suitable version of each
+// constraint before anything was chosen.
+type dependencyWalker struct {
+	sshClient    *ssh.Client
+	config       UpdateConfig
+	pinned       map[string]string
+	chosen       map[string]string
+	candidates   map[string][]string
+	dependencies map[string][]Dependency
+	// skipped holds the optional packages the walk left out
+	skipped map[string]bool
+	// invalid holds the version directories that are not semantic versions
+	invalid map[string]bool
+}
+
+func newDependencyWalker(updateConfig UpdateConfig, sshClient *ssh.Client) *dependencyWalker {
+	return &dependencyWalker{
+		sshClient:    sshClient,
+		config:       updateConfig,
+		pinned:       map[string]string{},
+		chosen:       map[string]string{},
+		candidates:   map[string][]string{},
+		dependencies: map[string][]Dependency{},
+		skipped:      map[string]bool{},
+		invalid:      map[string]bool{},
+	}
+}
+
+func (w *dependencyWalker) selectVersion(dependency Dependency) (string, error) {
+	if version, ok := w.pinned[dependency.Name]; ok {
+		if !satisfiesOperator(version, dependency.Operator, dependency.Version) {
+			return "", fmt.Errorf("version %s of package %s does not satisfy %s%s", version, dependency.Name, dependency.Operator, dependency.Version)
+		}
+		return version, nil
+	}
+	if version, ok := w.chosen[dependency.Name]; ok {
+		if version == "" {
+			return "", fmt.Errorf("no version of package %s satisfies all of its constraints", dependency.Name)
+		}
+		if satisfiesOperator(version, dependency.Operator, dependency.Version) {
+			return version, nil
+		}
+		// The chosen version is installed anyway, an optional constraint it
+		// does not satisfy is left out
+		if dependency.Optional {
+			return "", fmt.Errorf("version %s of package %s does not satisfy %s%s", version, dependency.Name, dependency.Operator, dependency.Version)
+		}
+	}
+
+	versions, err := w.suitableVersions(dependency)
+	if err != nil {
+		return "", err
+	}
+	if len(versions) == 0 {
+		return "", fmt.Errorf("no suitable versions found for package %s %s%s", dependency.Name, dependency.Operator, dependency.Version)
+	}
+	return versions[0], nil
+}
+
+// suitableVersions returns the versions satisfying one constraint, greatest
+// first.
+func (w *dependencyWalker) suitableVersions(dependency Dependency) ([]string, error) {
+	key := dependency.Name + " " + dependency.Operator + dependency.Version
+	if versions, ok := w.candidates[key]; ok {
+		return versions, nil
+	}
+
+	dependencyDir := filepath.Join("gopm_packages", dependency.Name)
+	versions, invalid, err := FindSuitableVersions(dependencyDir, dependency.Version, dependency.Operator, w.config.AllowPrerelease, w.sshClient)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find suitable versions for package %s: %w", dependency.Name, err)
+	}
+	for _, name := range invalid {
+		w.invalid[dependency.Name+"/"+name] = true
+	}
+
+	w.candidates[key] = versions
+	return versions, nil
+}
+
+func (w *dependencyWalker) dependenciesOf(name, version string) ([]Dependency, error) {
+	key := name + "@" + version
+	if dependencies, ok := w.dependencies[key]; ok {
+		return dependencies, nil
+	}
+
+	dependencies, err := fetchDependencies(filepath.Join("gopm_packages", name), version, w.sshClient)
+	if err != nil {
+		return nil, err
+	}
+
+	w.dependencies[key] = dependencies
+	return dependencies, nil
+}
+
+// walk follows dependency and everything it requires, calling visit with the
+// path leading to every package. Packages already on the path are visited but
+// not followed again, so dependency cycles terminate and their constraints
+// still count. If visit returns false the dependencies of that package are
+// skipped. Dependencies the update does not install and optional dependencies
+// without a suitable version are skipped. Shared dependencies are walked once
+// for every path to them, so only why lists paths; resolution uses graph.
+func (w *dependencyWalker) walk(path DependencyPath, dependency Dependency, visit func(DependencyPath) bool) error {
+	if !w.config.includes(dependency, len(path) == 0) {
+		return nil
+	}
+	cycle := false
+	for _, step := range path {
+		if step.Name == dependency.Name {
+			cycle = true
+			break
+		}
+	}
+
+	selected, err := w.selectVersion(dependency)
+	if err != nil {
+		if dependency.Optional {
+			w.skipped[dependency.Name] = true
+			return nil
+		}
+		return err
+	}
+
+	current := make(DependencyPath, len(path), len(path)+1)
+	copy(current, path)
+	current = append(current, DependencyStep{Dependency: dependency, Selected: selected})
+
+	if !visit(current) || cycle {
+		return nil
+	}
+
+	dependencies, err := w.dependenciesOf(dependency.Name, selected)
+	if err != nil {
+		return err
+	}
+	for _, nested := range dependencies {
+		err = w.walk(current, nested, visit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// walkAll walks every package of the update file.
+func (w *dependencyWalker) walkAll(visit func(DependencyPath) bool) error {
+	for _, update := range w.config.Updates {
+		if err := w.walk(nil, update, visit); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ExplainDependency returns every path from the packages listed in the update
+// file to packageName, with the constraint at each step and the version
+// update selects for it.
+func ExplainDependency(updateConfig UpdateConfig, packageName string, sshClient *ssh.Client) ([]DependencyPath, error) {
+	walker := newDependencyWalker(updateConfig, sshClient)
+	if _, err := walker.resolve(); err != nil {
+		return nil, err
+	}
+
+	var paths []DependencyPath
+	err := walker.walkAll(func(path DependencyPath) bool {
+		if path[len(path)-1].Name == packageName {
+			paths = append(paths, path)
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return paths, nil
+}
@@ -0,0 +1,40 @@
+package packager
+
+import "testing"
+
+// TestExplainDependencyMatchesUpdate checks that why shows the versions update
+// installs, not the greatest version of each constraint on its own.
+func TestExplainDependencyMatchesUpdate(t *testing.T) {
+	localRepository(t)
+	publishLocal(t, "a", "1.0.0", requires("c", ">=1.0.0"))
+	publishLocal(t, "b", "1.0.0", requires("c", "<2.0.0"))
+	publishLocal(t, "c", "1.0.0")
+	publishLocal(t, "c", "1.5.0")
+	publishLocal(t, "c", "2.0.0")
+
+	updateConfig := UpdateConfig{Updates: []Dependency{requires("a", ">=1.0.0"), requires("b", ">=1.0.0")}}
+	resolution, err := ResolveVersions(updateConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolution.Versions["c"] != "1.5.0" {
+		t.Fatalf("c resolved to %s, want 1.5.0", resolution.Versions["c"])
+	}
+
+	paths, err := ExplainDependency(updateConfig, "c", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"a >=1.0.0 (1.0.0) -> c >=1.0.0 (1.5.0)",
+		"b >=1.0.0 (1.0.0) -> c <2.0.0 (1.5.0)",
+	}
+	if len(paths) != len(want) {
+		t.Fatalf("got paths %v, want %v", paths, want)
+	}
+	for i, path := range paths {
+		if path.String() != want[i] {
+			t.Errorf("path %d: got %q, want %q", i, path, want[i])
+		}
+	}
+}
@@ -0,0 +1,169 @@
+package packager
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar/v4"
+)
+
+const IgnoreFileName = ".gopmignore"
+
+// ignoreRule is one pattern line of a .gopmignore file.
+type ignoreRule struct {
+	pattern string
+	negate  bool
+	dirOnly bool
+}
+
+// gopmIgnore applies the .gopmignore files found in baseDir and in the
+// directories below it with gitignore semantics: the last matching rule wins,
+// "!" re-includes a path, a trailing "/" only matches directories, a pattern
+// containing a slash is anchored to the directory of its .gopmignore and
+// anything inside an ignored directory is ignored as well.
+type gopmIgnore struct {
+	baseDir string
+	rules   map[string][]ignoreRule
+}
+
+func newGopmIgnore(baseDir string) (*gopmIgnore, error) {
+	absBaseDir, err := filepath.Abs(baseDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get absolute path of '%s': %w", baseDir, err)
+	}
+	return &gopmIgnore{baseDir: absBaseDir, rules: map[string][]ignoreRule{}}, nil
+}
+
+// ignored reports whether path is ignored by a .gopmignore file. Paths outside
+// of baseDir are never ignored.
+func (g *gopmIgnore) ignored(path string, isDir bool) (bool, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return false, fmt.Errorf("failed to get absolute path of '%s': %w", path, err)
+	}
+	relPath, err := filepath.Rel(g.baseDir, absPath)
+	if err != nil || !isLocalPath(relPath) || relPath == "." {
+		return false, nil
+	}
+
+	// A path inside an ignored directory cannot be re-included
+	parts := strings.Split(relPath, string(filepath.Separator))
+	for i := 1; i <= len(parts); i++ {
+		partIsDir := isDir || i < len(parts)
+		ignored, err := g.match(filepath.Join(parts[:i]...), partIsDir)
+		if err != nil {
+			return false, err
+		}
+		if ignored {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// match evaluates the rules of every .gopmignore between baseDir and relPath,
+// without looking at the parent directories of relPath.
+func (g *gopmIgnore) match(relPath string, isDir bool) (bool, error) {
+	ignored := false
+
+	dirs := []string{"."}
+	parts := strings.Split(filepath.Dir(relPath), string(filepath.Separator))
+	if parts[0] != "." {
+		for i := 1; i <= len(parts); i++ {
+			dirs = append(dirs, filepath.Join(parts[:i]...))
+		}
+	}
+
+	for _, dir := range dirs {
+		rules, err := g.load(dir)
+		if err != nil {
+			return false, err
+		}
+		subject, err := filepath.Rel(dir, relPath)
+		if err != nil {
+			return false, err
+		}
+		subject = filepath.ToSlash(subject)
+		for _, rule := range rules {
+			if rule.dirOnly && !isDir {
+				continue
+			}
+			matched, err := doublestar.Match(rule.pattern, subject)
+			if err != nil {
+				continue
+			}
+			if matched {
+				ignored = !rule.negate
+			}
+		}
+	}
+
+	return ignored, nil
+}
+
+// load reads the .gopmignore of a directory relative to baseDir, if any.
+func (g *gopmIgnore) load(dir string) ([]ignoreRule, error) {
+	if rules, ok := g.rules[dir]; ok {
+		return rules, nil
+	}
+
+	rules := []ignoreRule{}
+	file, err := os.Open(filepath.Join(g.baseDir, dir, IgnoreFileName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			g.rules[dir] = rules
+			return rules, nil
+		}
+		return nil, fmt.Errorf("failed to open %s: %w", IgnoreFileName, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if rule, ok := parseIgnoreRule(scanner.Text()); ok {
+			rules = append(rules, rule)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", filepath.Join(dir, IgnoreFileName), err)
+	}
+
+	g.rules[dir] = rules
+	return rules, nil
+}
+
+func parseIgnoreRule(line string) (ignoreRule, bool) {
+	rule := ignoreRule{}
+
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return rule, false
+	}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return rule, false
+	}
+
+	// Patterns without a slash match at any depth, others are anchored
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	rule.pattern = line
+
+	return rule, true
+}
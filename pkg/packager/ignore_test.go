@@ -0,0 +1,126 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// ignoreDir creates a directory with the given .gopmignore files, keyed by the
+// directory they are in, and returns the matcher for it.
+func ignoreDir(t *testing.T, files map[string]string) *gopmIgnore {
+	t.Helper()
+	baseDir := t.TempDir()
+	for dir, content := range files {
+		path := filepath.Join(baseDir, filepath.FromSlash(dir), IgnoreFileName)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ignore, err := newGopmIgnore(baseDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return ignore
+}
+
+type ignoreCase struct {
+	path  string
+	isDir bool
+	want  bool
+}
+
+func checkIgnored(t *testing.T, ignore *gopmIgnore, tests []ignoreCase) {
+	t.Helper()
+	for _, test := range tests {
+		got, err := ignore.ignored(filepath.Join(ignore.baseDir, filepath.FromSlash(test.path)), test.isDir)
+		if err != nil {
+			t.Fatalf("%s: %v", test.path, err)
+		}
+		if got != test.want {
+			t.Errorf("%s (dir %v): ignored %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+}
+
+func TestIgnoreRules(t *testing.T) {
+	ignore := ignoreDir(t, map[string]string{".": strings.Join([]string{
+		"# build output",
+		"*.log",
+		"!keep.log",
+		"build/",
+		"/top.txt",
+		"docs/*.tmp",
+		`\#hash`,
+		`\!bang`,
+		"trailing.txt   ",
+		"",
+	}, "\n")})
+
+	checkIgnored(t, ignore, []ignoreCase{
+		// patterns without a slash match at any depth
+		{"a.log", false, true},
+		{"sub/deep/b.log", false, true},
+		{"a.txt", false, false},
+		// negation
+		{"keep.log", false, false},
+		{"sub/keep.log", false, false},
+		// directory only
+		{"build", true, true},
+		{"build", false, false},
+		{"sub/build", true, true},
+		{"build/output.bin", false, true},
+		// anchoring
+		{"top.txt", false, true},
+		{"sub/top.txt", false, false},
+		{"docs/a.tmp", false, true},
+		{"sub/docs/a.tmp", false, false},
+		// escapes, comments and trailing spaces
+		{"#hash", false, true},
+		{"!bang", false, true},
+		{"# build output", false, false},
+		{"trailing.txt", false, true},
+	})
+
+	outside, err := ignore.ignored(filepath.Join(filepath.Dir(ignore.baseDir), "a.log"), false)
+	if err != nil || outside {
+		t.Errorf("path outside of the base directory: ignored %v, %v", outside, err)
+	}
+}
+
+func TestIgnoreNegationOrder(t *testing.T) {
+	tests := map[string][]ignoreCase{
+		// the last matching rule wins
+		"*.log\n!important.log": {{"important.log", false, false}, {"other.log", false, true}},
+		"!important.log\n*.log": {{"important.log", false, true}, {"other.log", false, true}},
+		// a path inside an ignored directory cannot be re-included
+		"build/\n!build/keep": {{"build/keep", false, true}},
+		// but the directory itself can be
+		"build/\n!build/": {{"build", true, false}, {"build/keep", false, false}},
+	}
+	for content, cases := range tests {
+		checkIgnored(t, ignoreDir(t, map[string]string{".": content}), cases)
+	}
+}
+
+func TestNestedIgnoreFiles(t *testing.T) {
+	ignore := ignoreDir(t, map[string]string{
+		".":   "*.log\n",
+		"sub": "!*.log\n/local.txt\n",
+	})
+
+	checkIgnored(t, ignore, []ignoreCase{
+		{"a.log", false, true},
+		{"sub/a.log", false, false},
+		{"sub/deep/a.log", false, false},
+		{"other/a.log", false, true},
+		// anchored to the directory of its .gopmignore
+		{"sub/local.txt", false, true},
+		{"sub/deep/local.txt", false, false},
+		{"local.txt", false, false},
+	})
+}
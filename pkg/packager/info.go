@@ -0,0 +1,109 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// FetchMetadata reads metadata.json of a package version on the remote
+// server. When version is empty the greatest released version is used.
+// Packages published before metadata.json existed only report their name and
+// version.
+func FetchMetadata(name, version string, sshClient *ssh.Client) (Metadata, error) {
+	dependencyDir := filepath.Join("gopm_packages", name)
+	if version == "" {
+		latest, err := latestRemoteVersion(dependencyDir, sshClient)
+		if err != nil {
+			return Metadata{}, fmt.Errorf("failed to list versions for package %s: %w", name, err)
+		}
+		version = latest
+	}
+
+	metadata := Metadata{Name: name, Version: version}
+
+	versionDir := filepath.Join(dependencyDir, version)
+	metadataFile := filepath.Join(versionDir, MetadataFileName)
+	command := fmt.Sprintf("if [ -f %s ]; then cat %s; elif [ ! -d %s ]; then exit 1; fi", metadataFile, metadataFile, versionDir)
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return metadata, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	output, err := session.Output(command)
+	session.Close()
+	if err != nil {
+		return metadata, fmt.Errorf("package %s %s not found on the remote server: %w", name, version, err)
+	}
+
+	content := strings.TrimSpace(string(output))
+	if content == "" {
+		return metadata, nil
+	}
+	if err := json.Unmarshal([]byte(content), &metadata); err != nil {
+		return metadata, fmt.Errorf("failed to parse metadata of %s %s: %w", name, version, err)
+	}
+
+	return metadata, nil
+}
+
+// ListPackages returns the metadata of the greatest version of every package
+// on the remote server. When labels are given only packages carrying one of
+// them are returned. Packages without versions or with unreadable metadata
+// are left out and their errors returned as skipped.
+func ListPackages(labels []string, sshClient *ssh.Client) (packages []Metadata, skipped []error, err error) {
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	command := "ls -d gopm_packages/*/ 2>/dev/null | xargs -n 1 basename"
+	output, err := session.Output(command)
+	session.Close()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to execute SSH command %s: %w", command, err)
+	}
+
+	packages = []Metadata{}
+	for _, name := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		if name == "" {
+			continue
+		}
+		metadata, err := FetchMetadata(name, "", sshClient)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		if len(labels) > 0 && !hasAnyLabel(metadata.Labels, labels) {
+			continue
+		}
+		packages = append(packages, metadata)
+	}
+
+	return packages, skipped, nil
+}
+
+// latestRemoteVersion returns the greatest released version in
+// dependencyDir, or the greatest pre-release if nothing is released yet.
+func latestRemoteVersion(dependencyDir string, sshClient *ssh.Client) (string, error) {
+	for _, allowPrerelease := range []bool{false, true} {
+		versions, _, err := FindSuitableVersions(dependencyDir, "0.0.0", ">=", allowPrerelease, sshClient)
+		if err != nil {
+			return "", err
+		}
+		if len(versions) > 0 {
+			return versions[0], nil
+		}
+	}
+	return "", fmt.Errorf("no versions found in %s", dependencyDir)
+}
+
+func hasAnyLabel(labels, wanted []string) bool {
+	for _, label := range wanted {
+		if contains(labels, label) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,116 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// targetDirs are directories that usually hold the files of a package.
+var targetDirs = []string{"bin", "build", "dist", "lib", "include", "share", "out"}
+
+// targetFiles match files that are usually shipped with a package.
+var targetFiles = []string{"README*", "LICENSE*", "COPYING*"}
+
+// DetectTargets suggests targets for a new package file in dir: the build
+// output directories, copied whole, and the README and license files it
+// contains.
+func DetectTargets(dir string) []string {
+	targets := []string{}
+	for _, name := range targetDirs {
+		if info, err := os.Stat(filepath.Join(dir, name)); err == nil && info.IsDir() {
+			targets = append(targets, "./"+name)
+		}
+	}
+	for _, pattern := range targetFiles {
+		matches, _ := filepath.Glob(filepath.Join(dir, pattern))
+		for _, match := range matches {
+			if info, err := os.Stat(match); err == nil && info.Mode().IsRegular() {
+				targets = append(targets, "./"+filepath.Base(match))
+			}
+		}
+	}
+	return targets
+}
+
+var invalidNameCharacters = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// SuggestName turns a directory name into a valid package name.
+func SuggestName(dir string) string {
+	name := invalidNameCharacters.ReplaceAllString(filepath.Base(dir), "-")
+	return strings.TrimLeft(name, "._-")
+}
+
+// ParseDependency reads a dependency written as on the command line, e.g.
+// "packet-2>=1.5" or "packet-3=2.0.0". "=" is written as "==", which every
+// version of gopm resolves.
+func ParseDependency(spec string) (Dependency, error) {
+	spec = strings.TrimSpace(spec)
+	index := strings.IndexAny(spec, "=<>")
+	if index < 0 {
+		return Dependency{}, fmt.Errorf("dependency %q has no version, e.g. %s>=1.0.0", spec, spec)
+	}
+
+	dependency := Dependency{Name: strings.TrimSpace(spec[:index])}
+	dependency.Version, dependency.Operator = extractVersionAndOperator(strings.TrimSpace(spec[index:]))
+	if dependency.Operator == "=" {
+		dependency.Operator = "=="
+	}
+	if dependency.Name == "" {
+		return Dependency{}, fmt.Errorf("dependency %q has no name", spec)
+	}
+	return dependency, nil
+}
+
+// WriteManifest writes pkg to a new JSON or YAML package file at path. The
+// package is checked like a package file that is read, so the file written
+// can be used by create as it is. An existing file is only replaced when
+// overwrite is set.
+func WriteManifest(path string, pkg *Package, overwrite bool) error {
+	var content []byte
+	var err error
+	switch ext := fileExtension(path); ext {
+	case ".json":
+		buf := new(bytes.Buffer)
+		encoder := json.NewEncoder(buf)
+		encoder.SetEscapeHTML(false)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(pkg)
+		content = buf.Bytes()
+	case ".yaml", ".yml":
+		content, err = yaml.Marshal(pkg)
+	default:
+		return fmt.Errorf("unsupported manifest format %q of %s, use .json, .yaml or .yml", ext, path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s: %w", path, err)
+	}
+
+	m := &manifest{path: path, content: content}
+	if err := m.validatePackage(pkg); err != nil {
+		return err
+	}
+
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	if !overwrite {
+		flags |= os.O_EXCL
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists", path)
+		}
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	if _, err := file.Write(content); err != nil {
+		file.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return file.Close()
+}
@@ -0,0 +1,123 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDetectTargets(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"bin", "src"} {
+		if err := os.Mkdir(filepath.Join(dir, name), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"README.md", "LICENSE", "main.go"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(dir, "bin", "sub"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "bin", "sub", "tool"), nil, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{"./bin", "./README.md", "./LICENSE"}
+	got := DetectTargets(dir)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got %q, want %q", got, want)
+	}
+
+	// The suggested targets package the directories with their layout
+	targets := []Target{}
+	for _, path := range got {
+		targets = append(targets, Target{Path: path})
+	}
+	packageDir := t.TempDir()
+	if err := copyTargets(targets, dir, packageDir, CreateOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(filepath.Join(packageDir, "bin", "sub", "tool")); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestWriteManifest(t *testing.T) {
+	dependency, err := ParseDependency("packet-2>=1.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := &Package{
+		Name:         "packet-1",
+		Version:      "0.1.0",
+		Targets:      []Target{{Path: "./bin/*"}, {Path: "./share/**", Dest: "data"}},
+		Dependencies: []Dependency{dependency},
+	}
+
+	for _, name := range []string{"packet.json", "packet.yaml"} {
+		path := filepath.Join(t.TempDir(), name)
+		if err := WriteManifest(path, want, false); err != nil {
+			t.Fatalf("failed to write %s: %v", name, err)
+		}
+		got, err := readCreateFile(path, nil)
+		if err != nil {
+			t.Fatalf("failed to read back %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s changed in a round trip:\n got %+v\nwant %+v", name, got, want)
+		}
+
+		err = WriteManifest(path, want, false)
+		if err == nil || !strings.Contains(err.Error(), "already exists") {
+			t.Errorf("%s written twice without overwrite: got error %v", name, err)
+		}
+	}
+
+	_, err = ParseDependency("packet-2")
+	if err == nil {
+		t.Error("dependency without a version was accepted")
+	}
+}
+
+// TestWrittenDependenciesResolve checks that the dependencies init writes
+// select a version when the package file is read back.
+func TestWrittenDependenciesResolve(t *testing.T) {
+	repository := versionDir(t, "1.0.0", "2.0.0", "2.1.0")
+
+	tests := map[string]string{
+		"packet-2=2.0.0":  "2.0.0",
+		"packet-2==2.0.0": "2.0.0",
+		"packet-2>=1.5":   "2.1.0",
+		"packet-2<2.0.0":  "1.0.0",
+	}
+	for spec, want := range tests {
+		dependency, err := ParseDependency(spec)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		path := filepath.Join(t.TempDir(), "packet.json")
+		pkg := &Package{Name: "packet-1", Version: "0.1.0", Targets: []Target{{Path: "./bin/*"}}, Dependencies: []Dependency{dependency}}
+		if err := WriteManifest(path, pkg, false); err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		read, err := readCreateFile(path, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+
+		written := read.Dependencies[0]
+		versions, _, err := FindSuitableVersions(repository, written.Version, written.Operator, false, nil)
+		if err != nil {
+			t.Fatalf("%s: %v", spec, err)
+		}
+		if len(versions) == 0 || versions[0] != want {
+			t.Errorf("%s: resolved to %q, want %s", spec, versions, want)
+		}
+	}
+}
@@ -0,0 +1,53 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const LockFileName = "gopm.lock"
+
+// LockFile records the exact version installed for every package resolved
+// from an update file, including transitive dependencies.
+type LockFile struct {
+	Packages map[string]string `json:"packages"`
+}
+
+// LockFilePath returns the path of the lock file that belongs to the update file.
+func LockFilePath(updateFile string) string {
+	return filepath.Join(filepath.Dir(updateFile), LockFileName)
+}
+
+func ReadLockFile(filePath string) (LockFile, error) {
+	var lock LockFile
+
+	fileContent, err := os.ReadFile(filePath)
+	if err != nil {
+		return lock, fmt.Errorf("failed to read lock file: %w", err)
+	}
+
+	if err := json.Unmarshal(fileContent, &lock); err != nil {
+		return lock, fmt.Errorf("failed to parse lock file: %w", err)
+	}
+	if lock.Packages == nil {
+		lock.Packages = map[string]string{}
+	}
+
+	return lock, nil
+}
+
+func WriteLockFile(filePath string, lock LockFile) error {
+	lockJSON, err := json.MarshalIndent(lock, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal lock file to JSON: %w", err)
+	}
+
+	err = os.WriteFile(filePath, append(lockJSON, '\n'), 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+
+	return nil
+}
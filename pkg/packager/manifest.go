@@ -0,0 +1,254 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/Masterminds/semver/v3"
+	"gopkg.in/yaml.v2"
+)
+
+// ManifestError is an error found in a package or update file. Line is the
+// line the error was found on, or 0 when it is not known.
+type ManifestError struct {
+	File string
+	Line int
+	Err  error
+}
+
+func (e *ManifestError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d: %v", e.File, e.Line, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.File, e.Err)
+}
+
+func (e *ManifestError) Unwrap() error {
+	return e.Err
+}
+
+// manifest is a package or update file read by loadManifest.
+type manifest struct {
+	path    string
+	content []byte
+}
+
+// loadManifest reads the JSON, YAML or TOML file at path into v. Fields that
+// v does not have are rejected.
+func loadManifest(path string, v interface{}) (*manifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	m := &manifest{path: path, content: content}
+
+	switch ext := fileExtension(path); ext {
+	case ".json":
+		if err := decodeStrictJSON(content, v); err != nil {
+			return nil, m.jsonError(err)
+		}
+	case ".yaml", ".yml":
+		if err := yaml.UnmarshalStrict(content, v); err != nil {
+			return nil, m.yamlError(err)
+		}
+	case ".toml":
+		// TOML is decoded through JSON so that Target, Patterns and
+		// Dependency accept the same forms as in JSON files
+		var document map[string]interface{}
+		if _, err := toml.Decode(string(content), &document); err != nil {
+			return nil, m.tomlError(err)
+		}
+		converted, err := json.Marshal(document)
+		if err != nil {
+			return nil, &ManifestError{File: path, Err: err}
+		}
+		if err := decodeStrictJSON(converted, v); err != nil {
+			return nil, m.convertedError(err)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported manifest format %q of %s", ext, path)
+	}
+
+	return m, nil
+}
+
+// errorf returns a ManifestError located at the first line containing the
+// n-th occurrence of needle, counting from 1.
+func (m *manifest) errorf(needle string, n int, format string, args ...interface{}) error {
+	return &ManifestError{File: m.path, Line: m.lineOf(needle, n), Err: fmt.Errorf(format, args...)}
+}
+
+func (m *manifest) lineOf(needle string, n int) int {
+	if needle == "" {
+		return 0
+	}
+	offset := 0
+	for i := 0; i < n; i++ {
+		index := bytes.Index(m.content[offset:], []byte(needle))
+		if index < 0 {
+			return 0
+		}
+		offset += index
+		if i < n-1 {
+			offset += len(needle)
+		}
+	}
+	return m.lineAt(int64(offset))
+}
+
+func (m *manifest) lineAt(offset int64) int {
+	if offset > int64(len(m.content)) {
+		offset = int64(len(m.content))
+	}
+	return bytes.Count(m.content[:offset], []byte("\n")) + 1
+}
+
+var unknownJSONField = regexp.MustCompile(`json: unknown field "(.*?)"`)
+
+func (m *manifest) jsonError(err error) error {
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	switch {
+	case errors.As(err, &syntaxErr):
+		return &ManifestError{File: m.path, Line: m.lineAt(syntaxErr.Offset), Err: err}
+	case errors.As(err, &typeErr):
+		return &ManifestError{File: m.path, Line: m.lineAt(typeErr.Offset), Err: fmt.Errorf("field %s must be of type %s", typeErr.Field, typeErr.Type)}
+	}
+	if match := unknownJSONField.FindStringSubmatch(err.Error()); match != nil {
+		return m.errorf(strconv.Quote(match[1]), 1, "unknown field %q", match[1])
+	}
+	return &ManifestError{File: m.path, Err: err}
+}
+
+var yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+var unknownYAMLField = regexp.MustCompile(`^field (.*) not found in type \S+$`)
+
+func (m *manifest) yamlError(err error) error {
+	messages := []string{err.Error()}
+	var typeErr *yaml.TypeError
+	if errors.As(err, &typeErr) {
+		messages = typeErr.Errors
+	}
+
+	// Report the first problem with its line, yaml reports them in order
+	match := yamlLine.FindStringSubmatch(messages[0])
+	if match == nil {
+		return &ManifestError{File: m.path, Err: err}
+	}
+	line, _ := strconv.Atoi(match[1])
+	message := match[2]
+	if field := unknownYAMLField.FindStringSubmatch(message); field != nil {
+		message = fmt.Sprintf("unknown field %q", field[1])
+	}
+	return &ManifestError{File: m.path, Line: line, Err: errors.New(message)}
+}
+
+var tomlLine = regexp.MustCompile(`^toml: line \d+( \(last key ".*?"\))?: `)
+
+func (m *manifest) tomlError(err error) error {
+	var parseErr toml.ParseError
+	if errors.As(err, &parseErr) {
+		message := tomlLine.ReplaceAllString(parseErr.Error(), "")
+		return &ManifestError{File: m.path, Line: parseErr.Position.Line, Err: errors.New(message)}
+	}
+	return &ManifestError{File: m.path, Err: err}
+}
+
+// convertedError locates an error found while decoding the JSON form of a
+// TOML file by the name of the field, the JSON offsets do not apply.
+func (m *manifest) convertedError(err error) error {
+	var typeErr *json.UnmarshalTypeError
+	if errors.As(err, &typeErr) {
+		fields := strings.Split(typeErr.Field, ".")
+		return m.errorf(fields[len(fields)-1], 1, "field %s must be of type %s", typeErr.Field, typeErr.Type)
+	}
+	if match := unknownJSONField.FindStringSubmatch(err.Error()); match != nil {
+		return m.errorf(match[1], 1, "unknown field %q", match[1])
+	}
+	return &ManifestError{File: m.path, Err: err}
+}
+
+// needle returns value as it is written in the file, quoted as in JSON when
+// that is found and as it is otherwise, to locate errors about it.
+func (m *manifest) needle(value string) string {
+	if quoted := strconv.Quote(value); bytes.Contains(m.content, []byte(quoted)) {
+		return quoted
+	}
+	return value
+}
+
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+var operators = []string{"", "=", "==", ">", ">=", "<", "<="}
+
+// validatePackage checks a package file after it was decoded.
+func (m *manifest) validatePackage(pkg *Package) error {
+	if pkg.Name == "" {
+		return m.errorf("", 0, "missing name")
+	}
+	if !namePattern.MatchString(pkg.Name) {
+		return m.errorf(m.needle(pkg.Name), 1, "invalid name %q: use letters, digits, '.', '_' and '-'", pkg.Name)
+	}
+	if pkg.Version == "" {
+		return m.errorf("", 0, "missing ver")
+	}
+	if _, err := semver.NewVersion(pkg.Version); err != nil {
+		return m.errorf(pkg.Version, 1, "invalid ver %q: not a semantic version", pkg.Version)
+	}
+
+	if len(pkg.Targets) == 0 {
+		return m.errorf("", 0, "no targets")
+	}
+	for _, target := range pkg.Targets {
+		if strings.TrimSpace(target.Path) == "" {
+			return m.errorf(`"path"`, 1, "target without a path")
+		}
+	}
+
+	return m.validateDependencies(pkg.Dependencies)
+}
+
+// validateUpdateConfig checks an update file after it was decoded.
+func (m *manifest) validateUpdateConfig(config *UpdateConfig) error {
+	for _, group := range config.Without {
+		if group != "dev" && group != "optional" {
+			return m.errorf(group, 1, "unknown dependency group %q in without, expected dev or optional", group)
+		}
+	}
+	return m.validateDependencies(config.Updates)
+}
+
+func (m *manifest) validateDependencies(dependencies []Dependency) error {
+	seen := map[string]int{}
+	for i, dependency := range dependencies {
+		seen[dependency.Name]++
+		needle := m.needle(dependency.Name)
+
+		if !namePattern.MatchString(dependency.Name) {
+			return m.errorf(needle, seen[dependency.Name], "invalid dependency name %q", dependency.Name)
+		}
+		if !contains(operators, dependency.Operator) {
+			return m.errorf(needle, seen[dependency.Name], "invalid operator %q in dependency %s", dependency.Operator, dependency.Name)
+		}
+		if _, err := semver.NewVersion(dependency.Version); err != nil {
+			return m.errorf(needle, seen[dependency.Name], "invalid version %q in dependency %s: not a semantic version", dependency.Version, dependency.Name)
+		}
+
+		// The same package may be listed again for other platforms or labels
+		for _, previous := range dependencies[:i] {
+			if previous.Name == dependency.Name && reflect.DeepEqual(previous.Conditions, dependency.Conditions) {
+				return m.errorf(needle, seen[dependency.Name], "duplicate dependency %s", dependency.Name)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,338 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/BurntSushi/toml"
+	"gopkg.in/yaml.v2"
+)
+
+const packageJSON = `{
+  "name": "packet-1",
+  "ver": "1.10.0",
+  "description": "Tools",
+  "license": "MIT",
+  "labels": ["cli"],
+  "targets": [
+    "./bin/*",
+    {"path": "./share/**", "exclude": "*.tmp, *.bak", "strip_prefix": "share", "dest": "data"},
+    {"path": "./docs/*", "include": ["*.md", "*.txt"]}
+  ],
+  "packets": [
+    {"name": "packet-2", "ver": ">=1.5"},
+    {"name": "packet-3", "ver": "2.0.0", "operator": "<="},
+    {"name": "packet-4", "ver": ">1.0", "optional": true, "os": ["linux"], "arch": ["amd64"]}
+  ]
+}
+`
+
+const packageYAML = `name: packet-1
+ver: 1.10.0
+description: Tools
+license: MIT
+labels: [cli]
+targets:
+  - ./bin/*
+  - path: ./share/**
+    exclude: "*.tmp, *.bak"
+    strip_prefix: share
+    dest: data
+  - path: ./docs/*
+    include: ["*.md", "*.txt"]
+packets:
+  - name: packet-2
+    ver: ">=1.5"
+  - name: packet-3
+    ver: 2.0.0
+    operator: "<="
+  - name: packet-4
+    ver: ">1.0"
+    optional: true
+    os: [linux]
+    arch: [amd64]
+`
+
+const packageTOML = `name = "packet-1"
+ver = "1.10.0"
+description = "Tools"
+license = "MIT"
+labels = ["cli"]
+targets = [
+  "./bin/*",
+  { path = "./share/**", exclude = "*.tmp, *.bak", strip_prefix = "share", dest = "data" },
+  { path = "./docs/*", include = ["*.md", "*.txt"] },
+]
+
+[[packets]]
+name = "packet-2"
+ver = ">=1.5"
+
+[[packets]]
+name = "packet-3"
+ver = "2.0.0"
+operator = "<="
+
+[[packets]]
+name = "packet-4"
+ver = ">1.0"
+optional = true
+os = ["linux"]
+arch = ["amd64"]
+`
+
+const updateJSON = `{
+  "packages": [
+    {"name": "packet-1", "ver": ">=1.10"},
+    {"name": "packet-5", "ver": ">=2.0", "dev": true, "labels": ["ci"]}
+  ],
+  "pre": true,
+  "without": ["optional"]
+}
+`
+
+const updateYAML = `packages:
+  - name: packet-1
+    ver: ">=1.10"
+  - name: packet-5
+    ver: ">=2.0"
+    dev: true
+    labels: [ci]
+pre: true
+without: [optional]
+`
+
+const updateTOML = `pre = true
+without = ["optional"]
+
+[[packages]]
+name = "packet-1"
+ver = ">=1.10"
+
+[[packages]]
+name = "packet-5"
+ver = ">=2.0"
+dev = true
+labels = ["ci"]
+`
+
+func writeManifest(t *testing.T, name, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPackageFormatsAreEquivalent(t *testing.T) {
+	want, err := readCreateFile(writeManifest(t, "packet.json", packageJSON), nil)
+	if err != nil {
+		t.Fatalf("failed to read JSON package file: %v", err)
+	}
+	if want.Dependencies[0].Operator != ">=" || want.Dependencies[0].Version != "1.5" {
+		t.Fatalf("operator was not split from ver: %+v", want.Dependencies[0])
+	}
+	if !reflect.DeepEqual(want.Targets[1].Exclude, Patterns{"*.tmp", "*.bak"}) {
+		t.Fatalf("exclude string was not split: %q", want.Targets[1].Exclude)
+	}
+
+	for name, content := range map[string]string{"packet.yaml": packageYAML, "packet.toml": packageTOML} {
+		got, err := readCreateFile(writeManifest(t, name, content), nil)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s differs from packet.json:\n got %+v\nwant %+v", name, got, want)
+		}
+	}
+}
+
+func TestUpdateFormatsAreEquivalent(t *testing.T) {
+	want, err := ReadUpdateFile(writeManifest(t, "packages.json", updateJSON), nil)
+	if err != nil {
+		t.Fatalf("failed to read JSON update file: %v", err)
+	}
+
+	for name, content := range map[string]string{"packages.yaml": updateYAML, "packages.toml": updateTOML} {
+		got, err := ReadUpdateFile(writeManifest(t, name, content), nil)
+		if err != nil {
+			t.Fatalf("failed to read %s: %v", name, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s differs from packages.json:\n got %+v\nwant %+v", name, got, want)
+		}
+	}
+}
+
+// TestPackageRoundTrip writes a package file in every format and reads it back.
+func TestPackageRoundTrip(t *testing.T) {
+	want, err := readCreateFile(writeManifest(t, "packet.json", packageJSON), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	encoders := map[string]func(interface{}) ([]byte, error){
+		"packet.json": json.Marshal,
+		"packet.yaml": yaml.Marshal,
+		"packet.toml": func(v interface{}) ([]byte, error) {
+			buf := new(bytes.Buffer)
+			err := toml.NewEncoder(buf).Encode(v)
+			return buf.Bytes(), err
+		},
+	}
+	for name, encode := range encoders {
+		content, err := encode(want)
+		if err != nil {
+			t.Fatalf("failed to encode %s: %v", name, err)
+		}
+		got, err := readCreateFile(writeManifest(t, name, string(content)), nil)
+		if err != nil {
+			t.Fatalf("failed to read back %s: %v\n%s", name, err, content)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s changed in a round trip:\n got %+v\nwant %+v", name, got, want)
+		}
+	}
+}
+
+func TestTOMLErrorsHavePositions(t *testing.T) {
+	tests := map[string]struct {
+		content string
+		want    string
+	}{
+		"unknown field": {
+			content: "name = \"p\"\nver = \"1.0.0\"\ntargets = [\"x\"]\nbogus = 1\n",
+			want:    "packet.toml:4: unknown field \"bogus\"",
+		},
+		"syntax": {
+			content: "name = \"p\"\nver = = \"1.0.0\"\ntargets = [\"x\"]\n",
+			want:    "packet.toml:2:",
+		},
+		"invalid version": {
+			content: "name = \"p\"\nver = \"one\"\ntargets = [\"x\"]\n",
+			want:    "packet.toml:2: invalid ver",
+		},
+		"unknown target field": {
+			content: "name = \"p\"\nver = \"1.0.0\"\ntargets = [{ path = \"x\", excludes = \"y\" }]\n",
+			want:    "unknown field \"excludes\"",
+		},
+	}
+	for name, test := range tests {
+		_, err := readCreateFile(writeManifest(t, "packet.toml", test.content), nil)
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("%s: got error %v, want it to contain %q", name, err, test.want)
+		}
+	}
+}
+
+func TestManifestErrorsHavePositions(t *testing.T) {
+	tests := []struct {
+		file    string
+		content string
+		want    string
+	}{
+		{"packet.json", "{\n  \"name\": \"p\",\n  \"ver\": \"1.0.0\",\n  \"targets\": [\"x\"],\n  \"bogus\": 1\n}\n", "packet.json:5: unknown field \"bogus\""},
+		{"packet.json", "{\n  \"name\": \"bad name\",\n  \"ver\": \"1.0.0\",\n  \"targets\": [\"x\"]\n}\n", "packet.json:2: invalid name \"bad name\""},
+		{"packet.json", "{\n  \"name\": \"p\",\n  \"ver\": \"one\",\n  \"targets\": [\"x\"]\n}\n", "packet.json:3: invalid ver \"one\""},
+		{"packet.json", "{\n  \"name\": \"p\",\n  \"ver\": 1,\n  \"targets\": [\"x\"]\n}\n", "packet.json:3: field ver must be of type string"},
+		{"packet.json", "{\n  \"name\": \"p\",\n  \"ver\": \"1.0.0\",\n  \"targets\": []\n}\n", "packet.json: no targets"},
+		{"packet.json", "{\n  \"name\": \"p\",\n  \"ver\": \"1.0.0\",\n  \"targets\": [\"x\"],\n  \"packets\": [\n    {\"name\": \"q\", \"ver\": \"1.0.0\"},\n    {\"name\": \"q\", \"ver\": \">=2.0.0\"}\n  ]\n}\n", "packet.json:7: duplicate dependency q"},
+		{"packet.json", "{\n  \"name\": \"p\",\n  \"ver\": \"1.0.0\",\n  \"targets\": [\"x\"],\n  \"packets\": [\n    {\"name\": \"q\", \"ver\": \">=x\"}\n  ]\n}\n", "packet.json:6: invalid version \"x\" in dependency q"},
+		{"packet.json", "{\n  \"name\": \"p\",\n  \"ver\": \"1.0.0\",\n  \"targets\": [\"x\"],\n  \"packets\": [\n    {\"name\": \"q\", \"ver\": \"1.0.0\", \"optinal\": true}\n  ]\n}\n", "packet.json:6: unknown field \"optinal\""},
+		{"packet.yaml", "name: p\nver: 1.0.0\ntargets: [x]\nbogus: 1\n", "packet.yaml:4: unknown field \"bogus\""},
+		{"packet.yaml", "name: bad name\nver: 1.0.0\ntargets: [x]\n", "packet.yaml:1: invalid name \"bad name\""},
+		{"packet.yaml", "name: p\nver: one\ntargets: [x]\n", "packet.yaml:2: invalid ver \"one\""},
+		{"packet.yaml", "name: p\nver: 1.0.0\ntargets: []\n", "packet.yaml: no targets"},
+		{"packet.yaml", "name: p\nver: 1.0.0\ntargets: [x]\npackets:\n  - name: q\n    ver: 1.0.0\n  - name: q\n    ver: \">=2.0.0\"\n", "packet.yaml:7: duplicate dependency q"},
+		{"packet.yaml", "name: p\nver: 1.0.0\ntargets: [x]\npackets:\n  - name: q\n    ver: 1.0.0\n    optinal: true\n", "packet.yaml:7: unknown field \"optinal\""},
+		{"packages.json", "{\n  \"packages\": [{\"name\": \"q\", \"ver\": \"1.0.0\"}],\n  \"without\": [\"docs\"]\n}\n", "packages.json:3: unknown dependency group \"docs\""},
+		{"packages.yaml", "packages:\n  - name: q\n    ver: 1.0.0\nwithout: [docs]\n", "packages.yaml:4: unknown dependency group \"docs\""},
+	}
+	for _, test := range tests {
+		var err error
+		if strings.HasPrefix(test.file, "packages") {
+			_, err = ReadUpdateFile(writeManifest(t, test.file, test.content), nil)
+		} else {
+			_, err = readCreateFile(writeManifest(t, test.file, test.content), nil)
+		}
+		if err == nil || !strings.Contains(err.Error(), test.want) {
+			t.Errorf("got error %v, want it to contain %q", err, test.want)
+		}
+	}
+}
+
+func TestPackageVariables(t *testing.T) {
+	t.Setenv("GOPM_TEST_PREFIX", "build")
+	t.Setenv("GOPM_TEST_VERSION", "9.9.9")
+	content := `{
+  "name": "packet-${FLAVOR}",
+  "ver": "${GOPM_TEST_VERSION}",
+  "vars": {"FLAVOR": "lite", "MIN": ">=1.5"},
+  "targets": [{"path": "./${GOPM_TEST_PREFIX}/*", "strip_prefix": "${GOPM_TEST_PREFIX}", "dest": "cost$$"}],
+  "packets": [
+    {"name": "packet-2", "ver": "${MIN}"}
+  ]
+}
+`
+	pkg, err := readCreateFile(writeManifest(t, "packet.json", content), map[string]string{"GOPM_TEST_VERSION": "2.0.0"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if pkg.Name != "packet-lite" {
+		t.Errorf("name from vars: got %q", pkg.Name)
+	}
+	if pkg.Version != "2.0.0" {
+		t.Errorf("version set on the command line should win over the environment: got %q", pkg.Version)
+	}
+	if target := pkg.Targets[0]; target.Path != "./build/*" || target.StripPrefix != "build" || target.Dest != "cost$" {
+		t.Errorf("target from the environment: got %+v", target)
+	}
+	if dependency := pkg.Dependencies[0]; dependency.Operator != ">=" || dependency.Version != "1.5" {
+		t.Errorf("constraint from vars: got %+v", dependency)
+	}
+
+	undefined := "{\n  \"name\": \"p\",\n  \"ver\": \"${GOPM_TEST_UNDEFINED}\",\n  \"targets\": [\"x\"]\n}\n"
+	_, err = readCreateFile(writeManifest(t, "packet.json", undefined), nil)
+	if err == nil || !strings.Contains(err.Error(), "packet.json:3: undefined variable GOPM_TEST_UNDEFINED") {
+		t.Errorf("undefined variable: got error %v", err)
+	}
+}
+
+func TestUpdateVariables(t *testing.T) {
+	t.Setenv("GOPM_TEST_CHANNEL", "stable")
+	content := `{
+  "vars": {"MIN": ">=1.5", "FLAVOR": "lite"},
+  "packages": [
+    {"name": "packet-${FLAVOR}", "ver": "${MIN}"},
+    {"name": "packet-2", "ver": "${PINNED}", "operator": "=="}
+  ],
+  "labels": ["${GOPM_TEST_CHANNEL}"]
+}
+`
+	config, err := ReadUpdateFile(writeManifest(t, "packages.json", content), map[string]string{"PINNED": "2.0.0", "FLAVOR": "full"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if dependency := config.Updates[0]; dependency.Name != "packet-full" || dependency.Operator != ">=" || dependency.Version != "1.5" {
+		t.Errorf("constraint from vars and the command line: got %+v", dependency)
+	}
+	if dependency := config.Updates[1]; dependency.Operator != "==" || dependency.Version != "2.0.0" {
+		t.Errorf("version from the command line: got %+v", dependency)
+	}
+	if !reflect.DeepEqual(config.Labels, []string{"stable"}) {
+		t.Errorf("label from the environment: got %q", config.Labels)
+	}
+
+	_, err = ReadUpdateFile(writeManifest(t, "packages.json", content), nil)
+	if err == nil || !strings.Contains(err.Error(), "packages.json:5: undefined variable PINNED") {
+		t.Errorf("undefined variable: got error %v", err)
+	}
+}
@@ -0,0 +1,137 @@
+package packager
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/bpva/gopm/pkg/archiver"
+)
+
+const MetadataFileName = "metadata.json"
+
+// Metadata describes a package version. It is stored as metadata.json next
+// to dependencies.json in the package directory.
+type Metadata struct {
+	Name    string `json:"name"`
+	Version string `json:"ver"`
+	// Format is the archive format the package is published in.
+	Format      archiver.Format `json:"format"`
+	Description string          `json:"description,omitempty"`
+	Authors     []string        `json:"authors,omitempty"`
+	// License is an SPDX license expression such as "MIT" or
+	// "Apache-2.0 OR MIT".
+	License    string   `json:"license,omitempty"`
+	Homepage   string   `json:"homepage,omitempty"`
+	Repository string   `json:"repository,omitempty"`
+	Labels     []string `json:"labels,omitempty"`
+}
+
+func createMetadataFile(metadata Metadata, metadataFile string) error {
+	metadataJSON, err := json.MarshalIndent(metadata, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal metadata to JSON: %w", err)
+	}
+
+	err = os.WriteFile(metadataFile, metadataJSON, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write metadata file: %w", err)
+	}
+
+	return nil
+}
+
+// validateMetadata checks the license expression and the URLs of the package.
+func validateMetadata(pkg *Package) error {
+	if pkg.License != "" {
+		if err := validateLicense(pkg.License); err != nil {
+			return err
+		}
+	}
+	for field, value := range map[string]string{"homepage": pkg.Homepage, "repository": pkg.Repository} {
+		if value == "" {
+			continue
+		}
+		u, err := url.Parse(value)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return fmt.Errorf("%s %q is not an absolute URL", field, value)
+		}
+	}
+	for _, label := range pkg.Labels {
+		if strings.TrimSpace(label) == "" || strings.Contains(label, ",") {
+			return fmt.Errorf("invalid label %q", label)
+		}
+	}
+	return nil
+}
+
+var licenseIDPattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9.\-]*\+?$`)
+
+// validateLicense checks the syntax of an SPDX license expression: license
+// identifiers joined by AND, OR and WITH, optionally grouped in parentheses.
+// The identifiers themselves are not checked against the SPDX license list.
+func validateLicense(license string) error {
+	tokens := strings.Fields(strings.NewReplacer("(", " ( ", ")", " ) ").Replace(license))
+
+	depth := 0
+	// expectID is true where a license identifier or an opening parenthesis
+	// must follow, false where an operator or a closing parenthesis must
+	expectID := true
+	afterWith := false
+	for _, token := range tokens {
+		switch {
+		case token == "(" && expectID && !afterWith:
+			depth++
+		case token == ")" && !expectID && depth > 0:
+			depth--
+		case (token == "AND" || token == "OR") && !expectID:
+			expectID = true
+		case token == "WITH" && !expectID:
+			expectID, afterWith = true, true
+		case expectID && licenseIDPattern.MatchString(token) && !isLicenseOperator(token):
+			expectID, afterWith = false, false
+		default:
+			return fmt.Errorf("license %q is not a valid SPDX expression", license)
+		}
+	}
+	if expectID || depth != 0 {
+		return fmt.Errorf("license %q is not a valid SPDX expression", license)
+	}
+	return nil
+}
+
+func isLicenseOperator(token string) bool {
+	return token == "AND" || token == "OR" || token == "WITH"
+}
+
+// ReadArchiveMetadata reads metadata.json of a package archive built by
+// CreatePackage and checks the name and version it gives, which decide where
+// the archive is published, and the format, which downloads rely on.
+func ReadArchiveMetadata(arch []byte) (Metadata, error) {
+	content, err := archiver.ReadFile(arch, MetadataFileName)
+	if err != nil {
+		return Metadata{}, err
+	}
+	var metadata Metadata
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return Metadata{}, fmt.Errorf("failed to parse %s: %w", MetadataFileName, err)
+	}
+	if !namePattern.MatchString(metadata.Name) {
+		return Metadata{}, fmt.Errorf("invalid package name %q in %s", metadata.Name, MetadataFileName)
+	}
+	if _, err := semver.NewVersion(metadata.Version); err != nil {
+		return Metadata{}, fmt.Errorf("invalid version %q in %s: not a semantic version", metadata.Version, MetadataFileName)
+	}
+	format, err := archiver.DetectFormat(arch)
+	if err != nil {
+		return Metadata{}, err
+	}
+	if metadata.Format != format {
+		return Metadata{}, fmt.Errorf("archive is %s, but %s records format %q", format, MetadataFileName, metadata.Format)
+	}
+	return metadata, nil
+}
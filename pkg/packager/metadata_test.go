@@ -0,0 +1,119 @@
+package packager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bpva/gopm/pkg/archiver"
+)
+
+func TestReadArchiveMetadata(t *testing.T) {
+	for _, format := range []archiver.Format{archiver.FormatZip, archiver.FormatTarGz, archiver.FormatTarZst} {
+		dir := t.TempDir()
+		if err := createMetadataFile(Metadata{Name: "packet-1", Version: "1.2.0", Format: format}, filepath.Join(dir, MetadataFileName)); err != nil {
+			t.Fatal(err)
+		}
+		arch, err := archiver.ArchiveWithOptions(dir, archiver.Options{Format: format})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		metadata, err := ReadArchiveMetadata(arch)
+		if err != nil {
+			t.Fatalf("%s: %v", format, err)
+		}
+		if metadata.Name != "packet-1" || metadata.Version != "1.2.0" {
+			t.Errorf("%s: got %+v", format, metadata)
+		}
+	}
+
+	dir := t.TempDir()
+	if err := createMetadataFile(Metadata{Name: "packet-1", Version: "1.2.0", Format: archiver.FormatZip}, filepath.Join(dir, MetadataFileName)); err != nil {
+		t.Fatal(err)
+	}
+	arch, err := archiver.ArchiveWithOptions(dir, archiver.Options{Format: archiver.FormatTarGz})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadArchiveMetadata(arch); err == nil {
+		t.Error("archive in another format than its metadata was accepted")
+	}
+
+	dir = t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arch, err = archiver.Archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadArchiveMetadata(arch); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("archive without metadata: got error %v", err)
+	}
+}
+
+func TestValidateLicense(t *testing.T) {
+	valid := []string{
+		"MIT",
+		"Apache-2.0",
+		"GPL-2.0+",
+		"MIT OR Apache-2.0",
+		"(MIT OR Apache-2.0) AND BSD-3-Clause",
+		"GPL-2.0-only WITH Classpath-exception-2.0",
+		"((MIT))",
+		"LicenseRef-Custom.1",
+	}
+	for _, license := range valid {
+		if err := validateLicense(license); err != nil {
+			t.Errorf("%q: %v", license, err)
+		}
+	}
+
+	invalid := []string{
+		"MIT OR",
+		"OR MIT",
+		"MIT Apache-2.0",
+		"MIT AND AND Apache-2.0",
+		"(MIT",
+		"MIT)",
+		"()",
+		"MIT WITH (Classpath-exception-2.0)",
+		"GNU GPL v2",
+		"MIT/X11",
+	}
+	for _, license := range invalid {
+		if err := validateLicense(license); err == nil {
+			t.Errorf("%q was accepted", license)
+		}
+	}
+}
+
+func TestValidateMetadata(t *testing.T) {
+	valid := Package{
+		License:    "MIT",
+		Homepage:   "https://example.com",
+		Repository: "git+ssh://git@example.com/p.git",
+		Labels:     []string{"cli", "tools"},
+	}
+	if err := validateMetadata(&valid); err != nil {
+		t.Errorf("valid metadata: %v", err)
+	}
+	if err := validateMetadata(&Package{}); err != nil {
+		t.Errorf("empty metadata: %v", err)
+	}
+
+	invalid := map[string]Package{
+		"license":          {License: "MIT or Apache-2.0"},
+		"relative URL":     {Homepage: "example.com/p"},
+		"URL without host": {Repository: "https:///p.git"},
+		"empty label":      {Labels: []string{" "}},
+		"label with comma": {Labels: []string{"cli,tools"}},
+	}
+	for name, pkg := range invalid {
+		if err := validateMetadata(&pkg); err == nil {
+			t.Errorf("%s was accepted", name)
+		}
+	}
+}
@@ -0,0 +1,135 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"golang.org/x/crypto/ssh"
+)
+
+type OutdatedPackage struct {
+	Name       string `json:"name"`
+	Constraint string `json:"constraint"`
+	Current    string `json:"current"`
+	Wanted     string `json:"wanted"`
+	Latest     string `json:"latest"`
+}
+
+// FindOutdated compares the versions installed under gopm_packages with the
+// versions available on the remote server. Every installed package is
+// checked, as well as every package the update file installs, directly or as
+// a dependency. Constraint lists the constraints the update file and the
+// selected dependencies put on the package, Wanted is the version update
+// selects for it and Latest the greatest version overall. Installed packages
+// the update file does not require have neither; those not on the server,
+// such as packages only built locally, are left out. Only packages that are
+// missing locally or have a newer remote version are returned, sorted by name.
+func FindOutdated(updateConfig UpdateConfig, sshClient *ssh.Client) ([]OutdatedPackage, error) {
+	return findOutdated(updateConfig, "gopm_packages", sshClient)
+}
+
+// findOutdated is FindOutdated with the packages installed in installedDir.
+func findOutdated(updateConfig UpdateConfig, installedDir string, sshClient *ssh.Client) ([]OutdatedPackage, error) {
+	walker := newDependencyWalker(updateConfig, sshClient)
+	resolution, err := walker.resolve()
+	if err != nil {
+		return nil, err
+	}
+	graph, err := walker.graph()
+	if err != nil {
+		return nil, err
+	}
+	constraints := map[string][]string{}
+	for name, dependencies := range graph.constraints {
+		for _, dependency := range dependencies {
+			constraint := dependency.Operator + dependency.Version
+			if !contains(constraints[name], constraint) {
+				constraints[name] = append(constraints[name], constraint)
+			}
+		}
+	}
+
+	names := []string{}
+	for name := range resolution.Versions {
+		names = append(names, name)
+	}
+	installed, err := os.ReadDir(installedDir)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to list installed packages: %w", err)
+	}
+	for _, entry := range installed {
+		if _, required := resolution.Versions[entry.Name()]; entry.IsDir() && !required {
+			names = append(names, entry.Name())
+		}
+	}
+	sort.Strings(names)
+
+	outdated := []OutdatedPackage{}
+	for _, name := range names {
+		wanted, required := resolution.Versions[name]
+
+		current, err := latestInstalledVersion(filepath.Join(installedDir, name))
+		if err != nil {
+			return nil, fmt.Errorf("failed to get installed versions for package %s: %w", name, err)
+		}
+
+		available, _, err := FindSuitableVersions(filepath.Join("gopm_packages", name), "0.0.0", ">=", updateConfig.AllowPrerelease, sshClient)
+		if err != nil || len(available) == 0 {
+			if !required {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("failed to list versions for package %s: %w", name, err)
+			}
+			return nil, fmt.Errorf("no versions found for package %s", name)
+		}
+
+		latest, err := semver.NewVersion(available[0])
+		if err != nil {
+			return nil, fmt.Errorf("invalid version %s for package %s: %w", available[0], name, err)
+		}
+		if current != nil && !current.LessThan(latest) {
+			continue
+		}
+
+		sort.Strings(constraints[name])
+		pkg := OutdatedPackage{
+			Name:       name,
+			Constraint: strings.Join(constraints[name], ", "),
+			Wanted:     wanted,
+			Latest:     available[0],
+		}
+		if current != nil {
+			pkg.Current = current.Original()
+		}
+		outdated = append(outdated, pkg)
+	}
+
+	return outdated, nil
+}
+
+// latestInstalledVersion returns the greatest version installed in
+// dependencyDir, or nil if the package is not installed.
+func latestInstalledVersion(dependencyDir string) (*semver.Version, error) {
+	if _, err := os.Stat(dependencyDir); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	installedVersions, err := getInstalledVersions(dependencyDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var latest *semver.Version
+	for _, version := range installedVersions {
+		if latest == nil || version.GreaterThan(latest) {
+			latest = version
+		}
+	}
+
+	return latest, nil
+}
@@ -0,0 +1,53 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestFindOutdated(t *testing.T) {
+	localRepository(t)
+	publishLocal(t, "a", "1.0.0", requires("c", ">=1.0.0"))
+	publishLocal(t, "a", "1.1.0", requires("c", ">=1.0.0"))
+	publishLocal(t, "b", "1.0.0", requires("c", "<2.0.0"))
+	publishLocal(t, "c", "1.0.0")
+	publishLocal(t, "c", "1.5.0")
+	publishLocal(t, "c", "2.0.0")
+	publishLocal(t, "d", "1.0.0")
+	publishLocal(t, "d", "2.0.0")
+
+	// d is installed but no longer required, e was only built locally
+	for _, dir := range []string{"a/1.0.0", "b/1.0.0", "c/1.0.0", "d/1.0.0", "e/0.1.0"} {
+		if err := os.MkdirAll(filepath.Join("installed", dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	updateConfig := UpdateConfig{Updates: []Dependency{requires("a", ">=1.0.0"), requires("b", "==1.0.0")}}
+	got, err := findOutdated(updateConfig, "installed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []OutdatedPackage{
+		{Name: "a", Constraint: ">=1.0.0", Current: "1.0.0", Wanted: "1.1.0", Latest: "1.1.0"},
+		{Name: "c", Constraint: "<2.0.0, >=1.0.0", Current: "1.0.0", Wanted: "1.5.0", Latest: "2.0.0"},
+		{Name: "d", Current: "1.0.0", Latest: "2.0.0"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got  %+v\nwant %+v", got, want)
+	}
+
+	// A required package that is not installed is reported as missing
+	if err := os.RemoveAll(filepath.Join("installed", "c")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = findOutdated(updateConfig, "installed", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[1].Name != "c" || got[1].Current != "" {
+		t.Errorf("missing dependency: got %+v", got)
+	}
+}
@@ -1,37 +1,80 @@
 package packager
 
 import (
+	"crypto/ed25519"
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+
+	"github.com/bpva/gopm/pkg/archiver"
 )
 
-func CreatePackage(packageFile string) (string, error) {
+// CreateOptions change how CreatePackage builds a package.
+type CreateOptions struct {
+	// Verbose prints every file left out of the package.
+	Verbose bool
+	// Dereference copies the files symlinks point to instead of the links.
+	Dereference bool
+	// Format is the archive format recorded in the package metadata.
+	Format archiver.Format
+	// SigningKey signs checksums.json when set.
+	SigningKey ed25519.PrivateKey
+	// Vars set variables of the package file, overriding its vars section
+	// and the environment.
+	Vars map[string]string
+	// Overwrite replaces an existing package directory of the same version.
+	// Without it CreatePackage fails when the directory exists.
+	Overwrite bool
+}
+
+func CreatePackage(packageFile string, options CreateOptions) (string, error) {
 	// Read the package file
-	mainPackage, err := readCreateFile(packageFile)
+	mainPackage, err := readCreateFile(packageFile, options.Vars)
 	if err != nil {
 		return "", fmt.Errorf("failed to read package file: %v", err)
 	}
+	err = validateMetadata(mainPackage)
+	if err != nil {
+		return "", fmt.Errorf("invalid package metadata: %v", err)
+	}
 
-	// Check if all dependencies exist and have suitable versions
+	// Check if all dependencies for this platform exist and have suitable versions
 	for _, dependency := range mainPackage.Dependencies {
+		if !dependency.matchesPlatform(runtime.GOOS, runtime.GOARCH) {
+			continue
+		}
 		err := checkDependency(dependency)
 		if err != nil {
+			if dependency.Optional {
+				fmt.Fprintf(os.Stderr, "warning: optional dependency %s: %v\n", dependency.Name, err)
+				continue
+			}
 			return "", fmt.Errorf("failed to check dependency: %v", err)
 		}
 	}
 
 	// Create the package directory
 	packageDir := filepath.Join("gopm_packages", mainPackage.Name, mainPackage.Version)
+	if _, err := os.Stat(packageDir); err == nil {
+		if !options.Overwrite {
+			return "", fmt.Errorf("package directory %s already exists, use -overwrite to replace it", packageDir)
+		}
+		err = os.RemoveAll(packageDir)
+		if err != nil {
+			return "", fmt.Errorf("failed to delete package directory: %v", err)
+		}
+	}
 	err = os.MkdirAll(packageDir, os.ModePerm)
 	if err != nil {
+		removePackageDir(packageDir)
 		return "", fmt.Errorf("failed to create package directory: %v", err)
 	}
 
 	// Copy targets to the package directory
-	err = copyTargets(mainPackage.Targets, packageDir)
+	err = copyTargets(mainPackage.Targets, filepath.Dir(packageFile), packageDir, options)
 	if err != nil {
-		_ = os.RemoveAll(packageDir)
+		removePackageDir(packageDir)
 		return "", fmt.Errorf("failed to copy targets: %v", err)
 	}
 
@@ -39,9 +82,54 @@ func CreatePackage(packageFile string) (string, error) {
 	dependenciesFile := filepath.Join(packageDir, "dependencies.json")
 	err = createDependenciesFile(mainPackage.Dependencies, dependenciesFile)
 	if err != nil {
-		_ = os.RemoveAll(packageDir)
+		removePackageDir(packageDir)
 		return "", fmt.Errorf("failed to create dependencies file: %v", err)
 	}
 
+	// Create metadata.json file in the package directory
+	format := options.Format
+	if format == "" {
+		format = archiver.DefaultFormat
+	}
+	metadata := Metadata{
+		Name:        mainPackage.Name,
+		Version:     mainPackage.Version,
+		Format:      format,
+		Description: mainPackage.Description,
+		Authors:     mainPackage.Authors,
+		License:     mainPackage.License,
+		Homepage:    mainPackage.Homepage,
+		Repository:  mainPackage.Repository,
+		Labels:      mainPackage.Labels,
+	}
+	err = createMetadataFile(metadata, filepath.Join(packageDir, MetadataFileName))
+	if err != nil {
+		removePackageDir(packageDir)
+		return "", fmt.Errorf("failed to create metadata file: %v", err)
+	}
+
+	// Create checksums.json file covering every other file of the package
+	err = createChecksumsFile(packageDir)
+	if err != nil {
+		removePackageDir(packageDir)
+		return "", fmt.Errorf("failed to create checksums file: %v", err)
+	}
+
+	// Sign checksums.json, which covers the rest of the package
+	if options.SigningKey != nil {
+		err = createSignatureFile(packageDir, options.SigningKey)
+		if err != nil {
+			removePackageDir(packageDir)
+			return "", fmt.Errorf("failed to sign package: %v", err)
+		}
+	}
+
 	return packageDir, nil
 }
+
+// removePackageDir deletes a package directory that failed to build, and the
+// directory of its package name when no other version is left in it.
+func removePackageDir(packageDir string) {
+	_ = os.RemoveAll(packageDir)
+	_ = os.Remove(filepath.Dir(packageDir))
+}
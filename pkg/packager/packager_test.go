@@ -0,0 +1,75 @@
+package packager
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreatePackageOverwrite(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	if err := os.WriteFile(filepath.Join(dir, "tool"), []byte("v1"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	packageFile := filepath.Join(dir, "packet.json")
+	content := `{"name": "packet-1", "ver": "1.0.0", "targets": ["./tool"]}`
+	if err := os.WriteFile(packageFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	packageDir, err := CreatePackage(packageFile, CreateOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreatePackage(packageFile, CreateOptions{}); err == nil || !strings.Contains(err.Error(), "-overwrite") {
+		t.Fatalf("existing package directory: got error %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "tool"), []byte("v2"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreatePackage(packageFile, CreateOptions{Overwrite: true}); err != nil {
+		t.Fatal(err)
+	}
+	contents, err := os.ReadFile(filepath.Join(packageDir, "tool"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(contents) != "v2" {
+		t.Errorf("overwritten package has %q, want v2", contents)
+	}
+}
+
+func TestCreatePackageCleansUp(t *testing.T) {
+	dir := t.TempDir()
+	chdir(t, dir)
+	packageFile := filepath.Join(dir, "packet.json")
+	content := `{"name": "packet-1", "ver": "1.0.0", "targets": ["./missing"]}`
+	if err := os.WriteFile(packageFile, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CreatePackage(packageFile, CreateOptions{}); err == nil {
+		t.Fatal("package with a missing target was created")
+	}
+	if _, err := os.Stat(filepath.Join("gopm_packages", "packet-1")); !os.IsNotExist(err) {
+		t.Errorf("failed build left gopm_packages/packet-1 behind: %v", err)
+	}
+
+	// Other versions of the package are kept
+	if err := os.MkdirAll(filepath.Join("gopm_packages", "packet-1", "0.9.0"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CreatePackage(packageFile, CreateOptions{}); err == nil {
+		t.Fatal("package with a missing target was created")
+	}
+	if _, err := os.Stat(filepath.Join("gopm_packages", "packet-1", "0.9.0")); err != nil {
+		t.Errorf("failed build removed another version: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join("gopm_packages", "packet-1", "1.0.0")); !os.IsNotExist(err) {
+		t.Errorf("failed build left its version directory behind: %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+package packager
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/bpva/gopm/pkg/archiver"
+	"golang.org/x/crypto/ssh"
+)
+
+// ErrVersionExists is returned by CheckPublish when the remote server already
+// has the version with other contents.
+var ErrVersionExists = errors.New("version already exists on the remote server")
+
+// CheckPublish reports whether the package archive arch has to be uploaded
+// as version of package name. It returns false when the remote server
+// already has the version with the same package digest, so publishing it
+// again changes nothing, and ErrVersionExists when the remote version differs
+// or its contents cannot be compared.
+func CheckPublish(arch []byte, name, version string, sshClient *ssh.Client) (bool, error) {
+	remote, exists, err := fetchRemoteChecksums(name, version, sshClient)
+	if err != nil {
+		return false, err
+	}
+	if !exists {
+		return true, nil
+	}
+	if remote == nil {
+		return false, fmt.Errorf("%w: %s %s has no %s to compare with", ErrVersionExists, name, version, ChecksumsFileName)
+	}
+
+	local, err := archiveChecksums(arch)
+	if err != nil {
+		return false, fmt.Errorf("%w: %v", ErrVersionExists, err)
+	}
+	if local.Digest != remote.Digest {
+		return false, fmt.Errorf("%w: %s %s has digest %s, the archive has %s", ErrVersionExists, name, version, remote.Digest, local.Digest)
+	}
+	return false, nil
+}
+
+// fetchRemoteChecksums reads checksums.json of a package version on the
+// remote server. exists is false when the server does not have the version;
+// the checksums are nil when the version was published without them.
+func fetchRemoteChecksums(name, version string, sshClient *ssh.Client) (checksums *Checksums, exists bool, err error) {
+	versionDir := filepath.Join("gopm_packages", name, version)
+	checksumsFile := filepath.Join(versionDir, ChecksumsFileName)
+	// Exit status 3 tells a missing version apart from a failed command
+	command := fmt.Sprintf("if [ -f %s ]; then cat %s; elif [ ! -d %s ]; then exit 3; fi", checksumsFile, checksumsFile, versionDir)
+	session, err := sshClient.NewSession()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to create SSH session: %w", err)
+	}
+	output, err := session.Output(command)
+	session.Close()
+	if exitErr, ok := err.(*ssh.ExitError); ok && exitErr.ExitStatus() == 3 {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to execute SSH command %s: %w", command, err)
+	}
+
+	if len(output) == 0 {
+		return nil, true, nil
+	}
+	checksums = &Checksums{}
+	if err := json.Unmarshal(output, checksums); err != nil {
+		return nil, true, fmt.Errorf("failed to parse %s of %s %s on the remote server: %w", ChecksumsFileName, name, version, err)
+	}
+	return checksums, true, nil
+}
+
+// archiveChecksums reads checksums.json of a package archive.
+func archiveChecksums(arch []byte) (Checksums, error) {
+	var checksums Checksums
+	content, err := archiver.ReadFile(arch, ChecksumsFileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return checksums, ErrNoChecksums
+	}
+	if err != nil {
+		return checksums, err
+	}
+	if err := json.Unmarshal(content, &checksums); err != nil {
+		return checksums, fmt.Errorf("failed to parse %s of the archive: %w", ChecksumsFileName, err)
+	}
+	return checksums, nil
+}
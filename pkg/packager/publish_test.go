@@ -0,0 +1,43 @@
+package packager
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/bpva/gopm/pkg/archiver"
+)
+
+func TestArchiveChecksums(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "file"), []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	arch, err := archiver.Archive(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := archiveChecksums(arch); !errors.Is(err, ErrNoChecksums) {
+		t.Errorf("archive without checksums: got error %v", err)
+	}
+
+	if err := createChecksumsFile(dir); err != nil {
+		t.Fatal(err)
+	}
+	want, err := computeChecksums(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	arch, err = archiver.ArchiveWithOptions(dir, archiver.Options{Format: archiver.FormatTarZst})
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := archiveChecksums(arch)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Digest != want.Digest {
+		t.Errorf("got digest %s, want %s", got.Digest, want.Digest)
+	}
+}
@@ -1,80 +1,100 @@
 package packager
 
 import (
+	"bytes"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
-	"os"
-	"path/filepath"
 	"strings"
-
-	"gopkg.in/yaml.v2"
 )
 
 type Update struct {
-	Name     string `json:"name" yaml:"name"`
-	Version  string `json:"ver" yaml:"ver"`
-	Operator string `json:"operator" yaml:"operator"`
+	Name     string `json:"name" yaml:"name" toml:"name"`
+	Version  string `json:"ver" yaml:"ver" toml:"ver"`
+	Operator string `json:"operator" yaml:"operator" toml:"operator"`
 }
 
 type UpdateConfig struct {
-	Updates []Dependency `json:"packages" yaml:"packages"`
+	// Vars are the values of ${NAME} references in the packages and labels of
+	// the update file.
+	Vars            map[string]string `json:"vars,omitempty" yaml:"vars,omitempty" toml:"vars,omitempty"`
+	Updates         []Dependency      `json:"packages" yaml:"packages" toml:"packages"`
+	AllowPrerelease bool              `json:"pre,omitempty" yaml:"pre,omitempty" toml:"pre,omitempty"`
+	// Without lists the dependency groups ("dev", "optional") to leave out.
+	Without []string `json:"without,omitempty" yaml:"without,omitempty" toml:"without,omitempty"`
+	// Labels select the dependencies restricted to labels.
+	Labels []string `json:"labels,omitempty" yaml:"labels,omitempty" toml:"labels,omitempty"`
+}
+
+// dependencyFields are the fields of a dependency in manifests and in
+// dependencies.json. The operator is either given on its own or as the
+// prefix of ver.
+type dependencyFields struct {
+	Name       *string `json:"name" yaml:"name" toml:"name"`
+	Version    *string `json:"ver" yaml:"ver" toml:"ver"`
+	Operator   string  `json:"operator" yaml:"operator" toml:"operator"`
+	Conditions `yaml:",inline"`
 }
 
 // Custom unmarshaler for the Dependency struct
 func (d *Dependency) UnmarshalJSON(data []byte) error {
-	var temp struct {
-		Name     string `json:"name"`
-		Version  string `json:"ver"`
-		Operator string `json:"operator"`
+	var fields dependencyFields
+	if err := decodeStrictJSON(data, &fields); err != nil {
+		return fmt.Errorf("invalid dependency: %w", err)
 	}
+	return d.setFields(fields)
+}
 
-	err := json.Unmarshal(data, &temp)
-	if err == nil && temp.Operator != "" {
-		d.Name = temp.Name
-		d.Version = temp.Version
-		d.Operator = temp.Operator
-		return nil
-	}
-	var depMap map[string]interface{}
-	err = json.Unmarshal(data, &depMap)
-	if err != nil {
+func (d *Dependency) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var fields dependencyFields
+	if err := unmarshal(&fields); err != nil {
 		return err
 	}
+	return d.setFields(fields)
+}
 
-	if name, ok := depMap["name"].(string); ok {
-		d.Name = name
-	} else {
-		return errors.New("missing or invalid name field in dependency")
+// MarshalJSON writes the operator as the prefix of ver, the form package
+// files are usually written in. Both forms are read back the same way.
+func (d Dependency) MarshalJSON() ([]byte, error) {
+	// Keep the operators readable
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	if err := encoder.Encode(d.fields()); err != nil {
+		return nil, err
 	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
 
-	if version, ok := depMap["ver"].(string); ok {
-		d.Version, d.Operator = extractVersionAndOperator(version)
-	} else {
-		return errors.New("missing or invalid ver field in dependency")
-	}
+func (d Dependency) MarshalYAML() (interface{}, error) {
+	return d.fields(), nil
+}
 
-	return nil
+// writtenDependency is the layout dependencies are written in.
+type writtenDependency struct {
+	Name       string `json:"name" yaml:"name"`
+	Version    string `json:"ver" yaml:"ver"`
+	Conditions `yaml:",inline"`
 }
 
-func (d *Dependency) UnmarshalYAML(unmarshal func(interface{}) error) error {
-	var depMap map[string]interface{}
-	err := unmarshal(&depMap)
-	if err != nil {
-		return err
-	}
+func (d Dependency) fields() writtenDependency {
+	return writtenDependency{Name: d.Name, Version: d.Operator + d.Version, Conditions: d.Conditions}
+}
 
-	if name, ok := depMap["name"].(string); ok {
-		d.Name = name
-	} else {
+func (d *Dependency) setFields(fields dependencyFields) error {
+	if fields.Name == nil {
 		return errors.New("missing or invalid name field in dependency")
 	}
+	if fields.Version == nil {
+		return fmt.Errorf("missing or invalid ver field in dependency %s", *fields.Name)
+	}
 
-	if version, ok := depMap["ver"].(string); ok {
-		d.Version, d.Operator = extractVersionAndOperator(version)
+	d.Name = *fields.Name
+	d.Conditions = fields.Conditions
+	if fields.Operator != "" {
+		d.Version, d.Operator = *fields.Version, fields.Operator
 	} else {
-		return errors.New("missing or invalid ver field in dependency")
+		d.Version, d.Operator = extractVersionAndOperator(*fields.Version)
 	}
 
 	return nil
@@ -97,19 +117,40 @@ func (t *Target) UnmarshalJSON(data []byte) error {
 	var path string
 	if err := json.Unmarshal(data, &path); err == nil {
 		t.Path = path
-		t.Exclude = ""
+		t.Exclude = nil
 		return nil
 	}
 
 	type targetAlias Target
-	return json.Unmarshal(data, (*targetAlias)(t))
+	return decodeStrictJSON(data, (*targetAlias)(t))
+}
+
+// MarshalJSON writes a target that only has a path as a plain string.
+func (t Target) MarshalJSON() ([]byte, error) {
+	if t.isPathOnly() {
+		return json.Marshal(t.Path)
+	}
+	type targetAlias Target
+	return json.Marshal(targetAlias(t))
+}
+
+func (t Target) MarshalYAML() (interface{}, error) {
+	if t.isPathOnly() {
+		return t.Path, nil
+	}
+	type targetAlias Target
+	return targetAlias(t), nil
+}
+
+func (t Target) isPathOnly() bool {
+	return len(t.Exclude) == 0 && len(t.Include) == 0 && t.StripPrefix == "" && t.Dest == ""
 }
 
 func (t *Target) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	var path string
 	if err := unmarshal(&path); err == nil {
 		t.Path = path
-		t.Exclude = ""
+		t.Exclude = nil
 		return nil
 	}
 
@@ -117,86 +158,105 @@ func (t *Target) UnmarshalYAML(unmarshal func(interface{}) error) error {
 	return unmarshal((*targetAlias)(t))
 }
 
-func readCreateFile(configFile string) (*Package, error) {
-	fileData, err := os.ReadFile(configFile)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read config file: %w", err)
+// Custom unmarshaler for Patterns accepting a list or a comma-separated string
+func (p *Patterns) UnmarshalJSON(data []byte) error {
+	var list string
+	if err := json.Unmarshal(data, &list); err == nil {
+		*p = splitPatterns(list)
+		return nil
 	}
 
-	ext := filepath.Ext(configFile)
+	var patterns []string
+	if err := json.Unmarshal(data, &patterns); err != nil {
+		return errors.New("patterns must be a string or a list of strings")
+	}
+	*p = patterns
+	return nil
+}
 
-	var pkg Package
-	switch ext {
-	case ".json":
-		err = json.Unmarshal(fileData, &pkg)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse JSON config file: %w", err)
-		}
-	case ".yaml", ".yml":
-		err = yaml.Unmarshal(fileData, &pkg)
-		if err != nil {
-			return nil, fmt.Errorf("failed to parse YAML config file: %w", err)
-		}
-	default:
-		return nil, fmt.Errorf("unsupported config file format: %s", ext)
+func (p *Patterns) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var list string
+	if err := unmarshal(&list); err == nil {
+		*p = splitPatterns(list)
+		return nil
 	}
 
-	return &pkg, nil
+	var patterns []string
+	if err := unmarshal(&patterns); err != nil {
+		return errors.New("patterns must be a string or a list of strings")
+	}
+	*p = patterns
+	return nil
+}
+
+func splitPatterns(list string) Patterns {
+	patterns := Patterns{}
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
 }
 
-func GetNameAndVersionFromConfigFile(configFilePath string) (string, string, error) {
-	fileData, err := os.ReadFile(configFilePath)
+// readCreateFile loads a package file, expands its variables with vars
+// taking precedence over the file and the environment, resolves a special
+// "ver" value and validates it.
+func readCreateFile(configFile string, vars map[string]string) (*Package, error) {
+	var pkg Package
+
+	m, err := loadManifest(configFile, &pkg)
 	if err != nil {
-		return "", "", fmt.Errorf("failed to read config file: %w", err)
+		return nil, err
+	}
+	if err := m.expandPackage(&pkg, vars); err != nil {
+		return nil, err
+	}
+	if err := m.resolveVersion(&pkg); err != nil {
+		return nil, err
+	}
+	if err := m.validatePackage(&pkg); err != nil {
+		return nil, err
 	}
 
-	ext := filepath.Ext(configFilePath)
+	return &pkg, nil
+}
 
-	var pkg Package
-	switch ext {
-	case ".json":
-		err = json.Unmarshal(fileData, &pkg)
-		if err != nil {
-			return "", "", fmt.Errorf("failed to parse JSON config file: %w", err)
-		}
-	case ".yaml", ".yml":
-		err = yaml.Unmarshal(fileData, &pkg)
-		if err != nil {
-			return "", "", fmt.Errorf("failed to parse YAML config file: %w", err)
-		}
-	default:
-		return "", "", fmt.Errorf("unsupported config file format: %s", ext)
+func GetNameAndVersionFromConfigFile(configFilePath string, vars map[string]string) (string, string, error) {
+	pkg, err := readCreateFile(configFilePath, vars)
+	if err != nil {
+		return "", "", err
 	}
 
 	return pkg.Name, pkg.Version, nil
 }
 
-func ReadUpdateFile(filePath string) (UpdateConfig, error) {
+// ReadUpdateFile loads an update file, expands its variables with vars
+// taking precedence over the file and the environment, and validates it.
+func ReadUpdateFile(filePath string, vars map[string]string) (UpdateConfig, error) {
 	var config UpdateConfig
 
-	fileContent, err := ioutil.ReadFile(filePath)
+	m, err := loadManifest(filePath, &config)
 	if err != nil {
-		return config, fmt.Errorf("failed to read update file: %w", err)
+		return config, err
 	}
-
-	fileExt := strings.ToLower(fileExtension(filePath))
-
-	switch fileExt {
-	case ".json":
-		if err := json.Unmarshal(fileContent, &config); err != nil {
-			return config, fmt.Errorf("failed to parse update file: %w", err)
-		}
-	case ".yaml", ".yml":
-		if err := yaml.Unmarshal(fileContent, &config); err != nil {
-			return config, fmt.Errorf("failed to parse update file: %w", err)
-		}
-	default:
-		return config, fmt.Errorf("unsupported file format: %s", fileExt)
+	if err := m.expandUpdateConfig(&config, vars); err != nil {
+		return config, err
+	}
+	if err := m.validateUpdateConfig(&config); err != nil {
+		return config, err
 	}
 
 	return config, nil
 }
 
+// decodeStrictJSON decodes data into v rejecting fields v does not have.
+func decodeStrictJSON(data []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	return decoder.Decode(v)
+}
+
 func fileExtension(filePath string) string {
 	filename := strings.ToLower(filePath)
 	lastDot := strings.LastIndex(filename, ".")
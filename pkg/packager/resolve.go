@@ -0,0 +1,208 @@
+package packager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// maxResolveRounds limits how often the dependency graph is walked again
+// after a selected version changed.
+const maxResolveRounds = 10
+
+// Resolution is the outcome of resolving an update file.
+type Resolution struct {
+	// Versions maps every package the update installs to its version.
+	Versions map[string]string
+	// Skipped lists the optional packages left out because no suitable
+	// version is available.
+	Skipped []string
+	// Invalid lists, as package/name, the version directories on the server
+	// that were ignored because their names are not semantic versions.
+	Invalid []string
+}
+
+// ResolveVersions selects the version of every package the update file
+// installs, including transitive dependencies. update, upgrade and why all
+// resolve through it.
+func ResolveVersions(updateConfig UpdateConfig, sshClient *ssh.Client) (Resolution, error) {
+	return newDependencyWalker(updateConfig, sshClient).resolve()
+}
+
+// resolve gives every package the greatest version that satisfies all
+// constraints on it, from the update file and from the selected versions of
+// the packages requiring it. Selecting another version of a package changes
+// its dependencies and so the constraints on others; the graph is walked
+// again until no selection changes. Only the packages reached by the last
+// walk are returned, whatever order the constraints were found in.
+func (w *dependencyWalker) resolve() (Resolution, error) {
+	for round := 0; round < maxResolveRounds; round++ {
+		graph, err := w.graph()
+		if err != nil {
+			return Resolution{}, err
+		}
+		constraints := graph.constraints
+
+		changed := false
+		for name, dependencies := range constraints {
+			if _, ok := w.pinned[name]; ok {
+				continue
+			}
+			version, err := w.selectAll(name, dependencies)
+			if err != nil {
+				return Resolution{}, err
+			}
+			if chosen, ok := w.chosen[name]; !ok || chosen != version {
+				w.chosen[name] = version
+				changed = true
+			}
+		}
+		if changed {
+			continue
+		}
+
+		resolution := Resolution{Versions: map[string]string{}, Skipped: []string{}, Invalid: []string{}}
+		for name := range constraints {
+			if version, ok := w.pinned[name]; ok {
+				resolution.Versions[name] = version
+			} else if w.chosen[name] != "" {
+				resolution.Versions[name] = w.chosen[name]
+			}
+		}
+		for name := range w.skipped {
+			if _, ok := resolution.Versions[name]; !ok {
+				resolution.Skipped = append(resolution.Skipped, name)
+			}
+		}
+		sort.Strings(resolution.Skipped)
+		for name := range w.invalid {
+			resolution.Invalid = append(resolution.Invalid, name)
+		}
+		sort.Strings(resolution.Invalid)
+		return resolution, nil
+	}
+
+	return Resolution{}, fmt.Errorf("dependency versions did not settle after %d rounds", maxResolveRounds)
+}
+
+// dependencyGraph is what one pass over the selected versions finds.
+type dependencyGraph struct {
+	// constraints holds the distinct constraints on every package reached
+	constraints map[string][]Dependency
+	// requires holds the packages each package depends on
+	requires map[string][]string
+}
+
+// graph follows the dependencies of the selected version of every package
+// once, however many paths lead to it, and collects the constraints found on
+// the way. Unlike walk it does not list the paths, whose number grows
+// exponentially with shared dependencies.
+func (w *dependencyWalker) graph() (dependencyGraph, error) {
+	w.skipped = map[string]bool{}
+	graph := dependencyGraph{constraints: map[string][]Dependency{}, requires: map[string][]string{}}
+	seen := map[string]bool{}
+	followed := map[string]bool{}
+
+	var follow func(parent string, dependency Dependency) error
+	follow = func(parent string, dependency Dependency) error {
+		if !w.config.includes(dependency, parent == "") {
+			return nil
+		}
+		selected, err := w.selectVersion(dependency)
+		if err != nil {
+			if dependency.Optional {
+				w.skipped[dependency.Name] = true
+				return nil
+			}
+			return err
+		}
+
+		key := fmt.Sprintf("%s %s%s %v", dependency.Name, dependency.Operator, dependency.Version, dependency.Optional)
+		if !seen[key] {
+			seen[key] = true
+			graph.constraints[dependency.Name] = append(graph.constraints[dependency.Name], dependency)
+		}
+		if parent != "" && !contains(graph.requires[parent], dependency.Name) {
+			graph.requires[parent] = append(graph.requires[parent], dependency.Name)
+		}
+
+		node := dependency.Name + "@" + selected
+		if followed[node] {
+			return nil
+		}
+		followed[node] = true
+		dependencies, err := w.dependenciesOf(dependency.Name, selected)
+		if err != nil {
+			return err
+		}
+		for _, nested := range dependencies {
+			if err := follow(dependency.Name, nested); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	for _, update := range w.config.Updates {
+		if err := follow("", update); err != nil {
+			return dependencyGraph{}, err
+		}
+	}
+	return graph, nil
+}
+
+// selectAll returns the greatest version of package name that satisfies
+// every one of dependencies, or when there is none, every one that is not
+// optional. It fails when even those cannot be satisfied together. When all
+// dependencies are optional the package is left out and "" is returned.
+func (w *dependencyWalker) selectAll(name string, dependencies []Dependency) (string, error) {
+	version, err := w.greatestCommonVersion(dependencies)
+	if err != nil || version != "" {
+		return version, err
+	}
+
+	required := []Dependency{}
+	constraints := []string{}
+	for _, dependency := range dependencies {
+		if !dependency.Optional {
+			required = append(required, dependency)
+			constraints = append(constraints, dependency.Operator+dependency.Version)
+		}
+	}
+	if len(required) == 0 {
+		return "", nil
+	}
+	version, err = w.greatestCommonVersion(required)
+	if err != nil || version != "" {
+		return version, err
+	}
+	sort.Strings(constraints)
+	return "", fmt.Errorf("no version of package %s satisfies %s", name, strings.Join(constraints, ", "))
+}
+
+// greatestCommonVersion returns the greatest version satisfying all of
+// dependencies, or "" if there is none.
+func (w *dependencyWalker) greatestCommonVersion(dependencies []Dependency) (string, error) {
+	allowed := map[string]int{}
+	var greatestFirst []string
+	for i, dependency := range dependencies {
+		versions, err := w.suitableVersions(dependency)
+		if err != nil {
+			return "", err
+		}
+		if i == 0 {
+			greatestFirst = versions
+		}
+		for _, version := range versions {
+			allowed[version]++
+		}
+	}
+	for _, version := range greatestFirst {
+		if allowed[version] == len(dependencies) {
+			return version, nil
+		}
+	}
+	return "", nil
+}
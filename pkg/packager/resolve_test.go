@@ -0,0 +1,254 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"testing"
+)
+
+// localRepository changes to an empty directory for the test. Packages added
+// with publishLocal are found there by the resolver when it runs without an
+// SSH client.
+func localRepository(t *testing.T) {
+	t.Helper()
+	chdir(t, t.TempDir())
+}
+
+func publishLocal(t *testing.T, name, version string, dependencies ...Dependency) {
+	t.Helper()
+	dir := filepath.Join("gopm_packages", name, version)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if dependencies == nil {
+		dependencies = []Dependency{}
+	}
+	if err := createDependenciesFile(dependencies, filepath.Join(dir, "dependencies.json")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func requires(name, constraint string) Dependency {
+	dependency := Dependency{Name: name}
+	dependency.Version, dependency.Operator = extractVersionAndOperator(constraint)
+	return dependency
+}
+
+func TestResolveVersionsDoesNotDependOnOrder(t *testing.T) {
+	localRepository(t)
+	publishLocal(t, "a", "1.0.0", requires("b", ">=1.0.0"))
+	publishLocal(t, "b", "1.0.0")
+	publishLocal(t, "b", "2.0.0")
+
+	want := map[string]string{"a": "1.0.0", "b": "1.0.0"}
+	orders := [][]Dependency{
+		{requires("a", ">=1.0.0"), requires("b", "<2.0.0")},
+		{requires("b", "<2.0.0"), requires("a", ">=1.0.0")},
+	}
+	for _, updates := range orders {
+		resolution, err := ResolveVersions(UpdateConfig{Updates: updates}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(resolution.Versions, want) {
+			t.Errorf("updates %v: got %v, want %v", updates, resolution.Versions, want)
+		}
+	}
+}
+
+func TestResolveVersionsFollowsSelectedVersions(t *testing.T) {
+	localRepository(t)
+	// c is only required by the version of a that is not selected
+	publishLocal(t, "a", "1.0.0", requires("c", ">=1.0.0"))
+	publishLocal(t, "a", "2.0.0", requires("b", ">=1.0.0"))
+	publishLocal(t, "b", "1.0.0", requires("a", "<2.0.0"))
+	publishLocal(t, "c", "1.0.0")
+
+	_, err := ResolveVersions(UpdateConfig{Updates: []Dependency{requires("a", ">=1.0.0")}}, nil)
+	if err == nil {
+		t.Fatal("conflicting constraints on a were resolved")
+	}
+
+	publishLocal(t, "b", "1.1.0")
+	resolution, err := ResolveVersions(UpdateConfig{Updates: []Dependency{requires("a", ">=1.0.0")}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "2.0.0", "b": "1.1.0"}
+	if !reflect.DeepEqual(resolution.Versions, want) {
+		t.Errorf("got %v, want %v", resolution.Versions, want)
+	}
+}
+
+func TestResolveUpgrade(t *testing.T) {
+	localRepository(t)
+	publishLocal(t, "a", "1.0.0", requires("b", ">=1.0.0"))
+	publishLocal(t, "a", "1.1.0", requires("c", ">=1.0.0"))
+	publishLocal(t, "b", "1.0.0")
+	publishLocal(t, "b", "1.2.0")
+	publishLocal(t, "c", "1.0.0")
+	publishLocal(t, "d", "1.0.0")
+	publishLocal(t, "d", "1.3.0")
+
+	updateConfig := UpdateConfig{Updates: []Dependency{requires("a", ">=1.0.0"), requires("d", ">=1.0.0")}}
+	lock := LockFile{Packages: map[string]string{"a": "1.0.0", "b": "1.0.0", "d": "1.0.0", "gone": "0.1.0"}}
+
+	resolution, err := ResolveUpgrade(updateConfig, lock, []string{"a"}, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"a": "1.1.0", "c": "1.0.0", "d": "1.0.0"}
+	if !reflect.DeepEqual(resolution.Versions, want) {
+		t.Errorf("upgrade a: got %v, want %v", resolution.Versions, want)
+	}
+
+	wantChanges := []LockChange{
+		{Name: "a", Old: "1.0.0", New: "1.1.0"},
+		{Name: "b", Old: "1.0.0"},
+		{Name: "c", New: "1.0.0"},
+		{Name: "gone", Old: "0.1.0"},
+	}
+	if changes := DiffLock(lock.Packages, resolution.Versions); !reflect.DeepEqual(changes, wantChanges) {
+		t.Errorf("lock diff: got %+v, want %+v", changes, wantChanges)
+	}
+
+	// Without locked packages upgrade resolves like update
+	full, err := ResolveVersions(updateConfig, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unlocked, err := ResolveUpgrade(updateConfig, LockFile{Packages: map[string]string{}}, nil, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(full, unlocked) {
+		t.Errorf("update resolved %v, upgrade without a lock %v", full.Versions, unlocked.Versions)
+	}
+	if len(DiffLock(full.Versions, unlocked.Versions)) != 0 {
+		t.Error("identical resolutions have a lock diff")
+	}
+}
+
+func TestResolveUpgradeRecursive(t *testing.T) {
+	localRepository(t)
+	publishLocal(t, "a", "1.0.0", requires("b", ">=1.0.0"))
+	publishLocal(t, "b", "1.0.0")
+	publishLocal(t, "b", "1.2.0")
+
+	updateConfig := UpdateConfig{Updates: []Dependency{requires("a", ">=1.0.0")}}
+	lock := LockFile{Packages: map[string]string{"a": "1.0.0", "b": "1.0.0"}}
+
+	for recursive, wantB := range map[bool]string{false: "1.0.0", true: "1.2.0"} {
+		resolution, err := ResolveUpgrade(updateConfig, lock, []string{"a"}, recursive, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := resolution.Versions["b"]; got != wantB {
+			t.Errorf("recursive %v: b resolved to %s, want %s", recursive, got, wantB)
+		}
+	}
+}
+
+func TestResolveVersionsSkipsOptional(t *testing.T) {
+	localRepository(t)
+	optional := requires("missing", ">=1.0.0")
+	optional.Optional = true
+	publishLocal(t, "a", "1.0.0", optional)
+
+	resolution, err := ResolveVersions(UpdateConfig{Updates: []Dependency{requires("a", ">=1.0.0")}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(resolution.Skipped, []string{"missing"}) || len(resolution.Versions) != 1 {
+		t.Errorf("got %+v", resolution)
+	}
+}
+
+func TestResolveVersionsConditions(t *testing.T) {
+	localRepository(t)
+	dev := requires("testlib", ">=1.0.0")
+	dev.Dev = true
+	native := requires("native", ">=1.0.0")
+	native.OS, native.Arch = []string{runtime.GOOS}, []string{runtime.GOARCH}
+	// not published, the resolver must not look them up
+	otherOS := requires("other-os", ">=1.0.0")
+	otherOS.OS = []string{"no-such-os"}
+	otherArch := requires("other-arch", ">=1.0.0")
+	otherArch.Arch = []string{"no-such-arch"}
+	gui := requires("gui", ">=1.0.0")
+	gui.Labels = []string{"desktop"}
+	extras := requires("extras", ">=1.0.0")
+	extras.Optional = true
+	publishLocal(t, "app", "1.0.0", dev, native, otherOS, otherArch, gui, extras)
+	for _, name := range []string{"testlib", "native", "gui", "tool", "extras"} {
+		publishLocal(t, name, "1.0.0")
+	}
+
+	tool := requires("tool", ">=1.0.0")
+	tool.Dev = true
+	updates := []Dependency{requires("app", ">=1.0.0"), tool}
+	tests := []struct {
+		config UpdateConfig
+		want   []string
+	}{
+		// dev dependencies only apply in the update file itself
+		{UpdateConfig{Updates: updates}, []string{"app", "extras", "native", "tool"}},
+		{UpdateConfig{Updates: updates, Without: []string{"dev"}}, []string{"app", "extras", "native"}},
+		{UpdateConfig{Updates: updates, Without: []string{"optional"}}, []string{"app", "native", "tool"}},
+		{UpdateConfig{Updates: updates, Labels: []string{"desktop"}}, []string{"app", "extras", "gui", "native", "tool"}},
+		{UpdateConfig{Updates: updates, Labels: []string{"server"}}, []string{"app", "extras", "native", "tool"}},
+	}
+	for _, test := range tests {
+		resolution, err := ResolveVersions(test.config, nil)
+		if err != nil {
+			t.Fatalf("without %v, labels %v: %v", test.config.Without, test.config.Labels, err)
+		}
+		got := []string{}
+		for name := range resolution.Versions {
+			got = append(got, name)
+		}
+		sort.Strings(got)
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("without %v, labels %v: got %v, want %v", test.config.Without, test.config.Labels, got, test.want)
+		}
+	}
+}
+
+// TestResolveSharedDependencies resolves a chain of diamonds, where every
+// layer has two packages both requiring the two of the next layer. Listing
+// the paths would take 2^layers steps.
+func TestResolveSharedDependencies(t *testing.T) {
+	localRepository(t)
+	const layers = 30
+	name := func(side string, layer int) string { return fmt.Sprintf("%s%d", side, layer) }
+	for layer := 0; layer < layers; layer++ {
+		var next []Dependency
+		if layer < layers-1 {
+			next = []Dependency{requires(name("a", layer+1), ">=1.0.0"), requires(name("b", layer+1), ">=1.0.0")}
+		}
+		publishLocal(t, name("a", layer), "1.0.0", next...)
+		publishLocal(t, name("b", layer), "1.0.0", next...)
+	}
+
+	updateConfig := UpdateConfig{Updates: []Dependency{requires("a0", ">=1.0.0"), requires("b0", ">=1.0.0")}}
+	walker := newDependencyWalker(updateConfig, nil)
+	resolution, err := walker.resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resolution.Versions) != 2*layers {
+		t.Errorf("resolved %d packages, want %d", len(resolution.Versions), 2*layers)
+	}
+
+	graph, err := walker.graph()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if constraints := graph.constraints[name("a", layers-1)]; len(constraints) != 1 {
+		t.Errorf("got constraints %v, want the one shared constraint", constraints)
+	}
+}
@@ -0,0 +1,184 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+)
+
+const (
+	// versionPattern matches the versions accepted by semver.NewVersion.
+	versionPattern = `^v?[0-9]+(\.[0-9]+){0,2}(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`
+	// constraintPattern matches a version with an optional operator prefix.
+	constraintPattern = `^(==|=|>=|<=|>|<)?v?[0-9]+(\.[0-9]+){0,2}(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?$`
+)
+
+// PackageSchema returns the JSON Schema of package files.
+func PackageSchema() ([]byte, error) {
+	return generateSchema("gopm package file", reflect.TypeOf(Package{}))
+}
+
+// UpdateSchema returns the JSON Schema of update files.
+func UpdateSchema() ([]byte, error) {
+	return generateSchema("gopm update file", reflect.TypeOf(UpdateConfig{}))
+}
+
+type schema map[string]interface{}
+
+// schemaGenerator builds a JSON Schema from the json tags of Go types.
+// Types with custom unmarshalers are described by schemaGenerator.custom and
+// placed in definitions.
+type schemaGenerator struct {
+	definitions map[string]schema
+}
+
+func generateSchema(title string, t reflect.Type) ([]byte, error) {
+	g := &schemaGenerator{definitions: map[string]schema{}}
+	root := g.typeSchema(t)
+	root["$schema"] = "http://json-schema.org/draft-07/schema#"
+	root["title"] = title
+	root["definitions"] = g.definitions
+
+	// Keep <, > and & readable in the patterns
+	buf := new(bytes.Buffer)
+	encoder := json.NewEncoder(buf)
+	encoder.SetEscapeHTML(false)
+	encoder.SetIndent("", "  ")
+	if err := encoder.Encode(root); err != nil {
+		return nil, fmt.Errorf("failed to marshal schema: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func (g *schemaGenerator) typeSchema(t reflect.Type) schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if name, ok := g.custom(t); ok {
+		return schema{"$ref": "#/definitions/" + name}
+	}
+
+	switch t.Kind() {
+	case reflect.String:
+		return schema{"type": "string"}
+	case reflect.Bool:
+		return schema{"type": "boolean"}
+	case reflect.Int, reflect.Int64:
+		return schema{"type": "integer"}
+	case reflect.Slice:
+		return schema{"type": "array", "items": g.typeSchema(t.Elem())}
+	case reflect.Map:
+		return schema{"type": "object", "additionalProperties": g.typeSchema(t.Elem())}
+	case reflect.Struct:
+		return g.structSchema(t)
+	default:
+		return schema{}
+	}
+}
+
+// structSchema describes a struct as an object without additional
+// properties. Fields without omitempty are required.
+func (g *schemaGenerator) structSchema(t reflect.Type) schema {
+	properties := schema{}
+	required := []string{}
+	g.addFields(t, properties, &required)
+
+	s := schema{
+		"type":                 "object",
+		"properties":           properties,
+		"additionalProperties": false,
+	}
+	if len(required) > 0 {
+		s["required"] = required
+	}
+	return s
+}
+
+func (g *schemaGenerator) addFields(t reflect.Type, properties schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if field.Anonymous && tag == "" {
+			g.addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		property := g.typeSchema(field.Type)
+		if pattern, ok := fieldPatterns[t.Name()+"."+name]; ok {
+			property["pattern"] = pattern
+		}
+		if format, ok := fieldFormats[t.Name()+"."+name]; ok {
+			property["format"] = format
+		}
+		if enum, ok := fieldItemEnums[t.Name()+"."+name]; ok {
+			property["items"].(schema)["enum"] = enum
+		}
+		properties[name] = property
+		if !strings.Contains(options, "omitempty") {
+			*required = append(*required, name)
+		}
+	}
+}
+
+// variableReference lets a field that is checked by a pattern contain ${NAME}
+// references instead, with any text but a single $ around them. Values with
+// references are checked after expansion.
+const variableReference = `|^([^$]|\$\$)*(\$\{[A-Za-z_][A-Za-z0-9_]*\}([^$]|\$\$)*)+$`
+
+var fieldPatterns = map[string]string{
+	"Package.name":          namePattern.String() + variableReference,
+	"Package.ver":           versionPattern + variableReference + `|^git-describe$|^file:.+|^env:.+`,
+	"dependencyFields.name": namePattern.String() + variableReference,
+	"dependencyFields.ver":  constraintPattern + variableReference,
+}
+
+// fieldItemEnums lists the values allowed in the items of list fields.
+var fieldItemEnums = map[string][]string{
+	"UpdateConfig.without": {"dev", "optional"},
+}
+
+var fieldFormats = map[string]string{
+	"Package.homepage":   "uri",
+	"Package.repository": "uri",
+}
+
+// custom adds the schema of types with custom unmarshalers to definitions
+// and returns their name.
+func (g *schemaGenerator) custom(t reflect.Type) (string, bool) {
+	var s schema
+	switch t {
+	case reflect.TypeOf(Target{}):
+		type targetAlias Target
+		s = schema{"oneOf": []schema{
+			{"type": "string", "description": "Path or glob pattern of the files to package"},
+			g.structSchema(reflect.TypeOf(targetAlias{})),
+		}}
+	case reflect.TypeOf(Patterns{}):
+		s = schema{"oneOf": []schema{
+			{"type": "string", "description": "Comma-separated patterns"},
+			{"type": "array", "items": schema{"type": "string"}},
+		}}
+	case reflect.TypeOf(Dependency{}):
+		s = g.structSchema(reflect.TypeOf(dependencyFields{}))
+		s["properties"].(schema)["operator"] = schema{"type": "string", "enum": operators}
+		s["required"] = []string{"name", "ver"}
+		s["description"] = `A package with a version constraint such as ">=1.2.0"`
+	default:
+		return "", false
+	}
+
+	name := t.Name()
+	if _, ok := g.definitions[name]; !ok {
+		g.definitions[name] = s
+	}
+	return name, true
+}
@@ -0,0 +1,78 @@
+package packager
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+)
+
+func TestSchemaPatterns(t *testing.T) {
+	name := regexp.MustCompile(fieldPatterns["Package.name"])
+	for _, value := range []string{"packet-1", "packet-${FLAVOR}", "${NAME}", "${A}-${B}", "cost$$-${A}"} {
+		if !name.MatchString(value) {
+			t.Errorf("name %q was rejected", value)
+		}
+	}
+	for _, value := range []string{"bad name", "-packet", "$NAME", "packet-${A", "${A}-$x", "${1A}"} {
+		if name.MatchString(value) {
+			t.Errorf("name %q was accepted", value)
+		}
+	}
+
+	constraint := regexp.MustCompile(fieldPatterns["dependencyFields.ver"])
+	for _, value := range []string{">=1.5", "==2.0.0-rc1", "${MIN}", ">=${MAJOR}.0"} {
+		if !constraint.MatchString(value) {
+			t.Errorf("ver %q was rejected", value)
+		}
+	}
+	for _, value := range []string{"latest", "~1.2", ">=1.x"} {
+		if constraint.MatchString(value) {
+			t.Errorf("ver %q was accepted", value)
+		}
+	}
+}
+
+func TestUpdateSchemaWithout(t *testing.T) {
+	content, err := UpdateSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var document struct {
+		Properties map[string]struct {
+			Items struct {
+				Enum []string `json:"enum"`
+			} `json:"items"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(content, &document); err != nil {
+		t.Fatal(err)
+	}
+	if got := document.Properties["without"].Items.Enum; !reflect.DeepEqual(got, []string{"dev", "optional"}) {
+		t.Errorf("without allows %q", got)
+	}
+}
+
+// TestShippedSchemas checks that the schemas in the schema directory are the
+// generated ones. Run make schema after changing the types.
+func TestShippedSchemas(t *testing.T) {
+	for file, generate := range map[string]func() ([]byte, error){
+		"package.schema.json": PackageSchema,
+		"update.schema.json":  UpdateSchema,
+	} {
+		want, err := generate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := os.ReadFile(filepath.Join("..", "..", "schema", file))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Errorf("schema/%s is out of date", file)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package packager
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const SignatureFileName = "signature.json"
+
+// Signature is a detached Ed25519 signature over checksums.json. It is
+// stored as signature.json in the package directory.
+type Signature struct {
+	// Key is the public key of the signer in authorized_keys format.
+	Key string `json:"key"`
+	// Signature is the base64 encoded signature of checksums.json.
+	Signature string `json:"signature"`
+}
+
+// ErrUnsigned is returned by VerifySignature for packages without a
+// signature.
+var ErrUnsigned = errors.New("package is not signed")
+
+// ErrUntrusted is returned by VerifySignature for packages signed by a key
+// that is not in the keyring.
+var ErrUntrusted = errors.New("package is signed by an untrusted key")
+
+func createSignatureFile(packageDir string, key ed25519.PrivateKey) error {
+	checksums, err := os.ReadFile(filepath.Join(packageDir, ChecksumsFileName))
+	if err != nil {
+		return fmt.Errorf("failed to read checksums file: %w", err)
+	}
+
+	publicKey, err := ssh.NewPublicKey(key.Public())
+	if err != nil {
+		return fmt.Errorf("failed to encode public key: %w", err)
+	}
+	signature := Signature{
+		Key:       strings.TrimSpace(string(ssh.MarshalAuthorizedKey(publicKey))),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, checksums)),
+	}
+
+	signatureJSON, err := json.MarshalIndent(signature, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal signature to JSON: %w", err)
+	}
+
+	err = os.WriteFile(filepath.Join(packageDir, SignatureFileName), signatureJSON, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write signature file: %w", err)
+	}
+
+	return nil
+}
+
+// VerifySignature checks that checksums.json in packageDir is signed by one
+// of the trusted keys. The files themselves are checked by VerifyChecksums.
+func VerifySignature(packageDir string, trustedKeys []ed25519.PublicKey) error {
+	content, err := os.ReadFile(filepath.Join(packageDir, SignatureFileName))
+	if os.IsNotExist(err) {
+		return ErrUnsigned
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read signature file: %w", err)
+	}
+
+	var signature Signature
+	if err := json.Unmarshal(content, &signature); err != nil {
+		return fmt.Errorf("failed to parse signature file: %w", err)
+	}
+	publicKey, _, _, _, err := ssh.ParseAuthorizedKey([]byte(signature.Key))
+	if err != nil {
+		return fmt.Errorf("failed to parse signer key: %w", err)
+	}
+	signatureBytes, err := base64.StdEncoding.DecodeString(signature.Signature)
+	if err != nil {
+		return fmt.Errorf("failed to decode signature: %w", err)
+	}
+
+	checksums, err := os.ReadFile(filepath.Join(packageDir, ChecksumsFileName))
+	if err != nil {
+		return fmt.Errorf("failed to read checksums file: %w", err)
+	}
+
+	for _, key := range trustedKeys {
+		trustedKey, err := ssh.NewPublicKey(key)
+		if err != nil {
+			return fmt.Errorf("failed to encode trusted key: %w", err)
+		}
+		if string(trustedKey.Marshal()) != string(publicKey.Marshal()) {
+			continue
+		}
+		if !ed25519.Verify(key, checksums, signatureBytes) {
+			return fmt.Errorf("invalid signature by %s", ssh.FingerprintSHA256(publicKey))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("%w %s", ErrUntrusted, ssh.FingerprintSHA256(publicKey))
+}
@@ -0,0 +1,68 @@
+package packager
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestVerifySignature(t *testing.T) {
+	publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signedPackage := func(t *testing.T) string {
+		t.Helper()
+		dir := t.TempDir()
+		if err := os.WriteFile(filepath.Join(dir, "tool"), []byte("tool"), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := createChecksumsFile(dir); err != nil {
+			t.Fatal(err)
+		}
+		if err := createSignatureFile(dir, privateKey); err != nil {
+			t.Fatal(err)
+		}
+		return dir
+	}
+
+	dir := signedPackage(t)
+	if err := VerifySignature(dir, []ed25519.PublicKey{otherKey, publicKey}); err != nil {
+		t.Errorf("signed package: %v", err)
+	}
+	if err := VerifySignature(dir, []ed25519.PublicKey{otherKey}); !errors.Is(err, ErrUntrusted) {
+		t.Errorf("signed by another key: got error %v, want ErrUntrusted", err)
+	}
+
+	dir = signedPackage(t)
+	checksumsFile := filepath.Join(dir, ChecksumsFileName)
+	content, err := os.ReadFile(checksumsFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tampered := strings.Replace(string(content), `"digest": "`, `"digest": "0`, 1)
+	if err := os.WriteFile(checksumsFile, []byte(tampered), 0644); err != nil {
+		t.Fatal(err)
+	}
+	err = VerifySignature(dir, []ed25519.PublicKey{publicKey})
+	if err == nil || errors.Is(err, ErrUntrusted) || errors.Is(err, ErrUnsigned) {
+		t.Errorf("tampered %s: got error %v, want an invalid signature", ChecksumsFileName, err)
+	}
+
+	dir = signedPackage(t)
+	if err := os.Remove(filepath.Join(dir, SignatureFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifySignature(dir, []ed25519.PublicKey{publicKey}); !errors.Is(err, ErrUnsigned) {
+		t.Errorf("unsigned package: got error %v, want ErrUnsigned", err)
+	}
+}
@@ -0,0 +1,74 @@
+package packager
+
+import (
+	"sort"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// ResolveUpgrade re-resolves the update file keeping every lock entry fixed
+// except for the named packages. With recursive set the packages they depend
+// on are unlocked as well. Packages that are no longer required are not part
+// of the resolution, even if they are locked.
+func ResolveUpgrade(updateConfig UpdateConfig, lock LockFile, packages []string, recursive bool, sshClient *ssh.Client) (Resolution, error) {
+	unlocked := map[string]bool{}
+	for _, name := range packages {
+		unlocked[name] = true
+	}
+
+	if recursive {
+		walker := newDependencyWalker(updateConfig, sshClient)
+		for name, version := range lock.Packages {
+			walker.pinned[name] = version
+		}
+		graph, err := walker.graph()
+		if err != nil {
+			return Resolution{}, err
+		}
+		queue := append([]string{}, packages...)
+		for len(queue) > 0 {
+			name := queue[0]
+			queue = queue[1:]
+			for _, required := range graph.requires[name] {
+				if !unlocked[required] {
+					unlocked[required] = true
+					queue = append(queue, required)
+				}
+			}
+		}
+	}
+
+	walker := newDependencyWalker(updateConfig, sshClient)
+	for name, version := range lock.Packages {
+		if !unlocked[name] {
+			walker.pinned[name] = version
+		}
+	}
+	return walker.resolve()
+}
+
+// LockChange is a difference between two lock files. Old is empty for an
+// added package, New for a removed one.
+type LockChange struct {
+	Name string
+	Old  string
+	New  string
+}
+
+// DiffLock lists the packages added, removed or changed from the versions
+// before to the versions after, sorted by name.
+func DiffLock(before, after map[string]string) []LockChange {
+	changes := []LockChange{}
+	for name, oldVersion := range before {
+		if newVersion := after[name]; newVersion != oldVersion {
+			changes = append(changes, LockChange{Name: name, Old: oldVersion, New: newVersion})
+		}
+	}
+	for name, newVersion := range after {
+		if _, ok := before[name]; !ok {
+			changes = append(changes, LockChange{Name: name, New: newVersion})
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool { return changes[i].Name < changes[j].Name })
+	return changes
+}
@@ -0,0 +1,134 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+)
+
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// variables looks up the values of ${NAME} references in a package or update
+// file. Values given on the command line win over the vars section of the
+// file, which wins over the environment.
+type variables struct {
+	overrides map[string]string
+	vars      map[string]string
+}
+
+func (v variables) lookup(name string) (string, bool) {
+	if value, ok := v.overrides[name]; ok {
+		return value, true
+	}
+	if value, ok := v.vars[name]; ok {
+		return value, true
+	}
+	return os.LookupEnv(name)
+}
+
+// expand replaces every ${NAME} in s with its value. $$ stands for a single $.
+// A reference to an undefined variable is an error.
+func (v variables) expand(s string) (string, error) {
+	var expanded strings.Builder
+	for i := 0; i < len(s); i++ {
+		switch {
+		case strings.HasPrefix(s[i:], "$$"):
+			expanded.WriteByte('$')
+			i++
+		case strings.HasPrefix(s[i:], "${"):
+			end := strings.IndexByte(s[i:], '}')
+			if end < 0 {
+				return s, fmt.Errorf("unterminated variable reference in %q", s)
+			}
+			name := s[i+2 : i+end]
+			if !variableNamePattern.MatchString(name) {
+				return s, fmt.Errorf("invalid variable name %q in %q", name, s)
+			}
+			value, ok := v.lookup(name)
+			if !ok {
+				return s, &undefinedVariableError{name: name}
+			}
+			expanded.WriteString(value)
+			i += end
+		default:
+			expanded.WriteByte(s[i])
+		}
+	}
+	return expanded.String(), nil
+}
+
+type undefinedVariableError struct {
+	name string
+}
+
+func (e *undefinedVariableError) Error() string {
+	return fmt.Sprintf("undefined variable %s", e.name)
+}
+
+// expandPackage expands the variables in the name, version, targets and
+// dependencies of a package file.
+func (m *manifest) expandPackage(pkg *Package, overrides map[string]string) error {
+	v := variables{overrides: overrides, vars: pkg.Vars}
+
+	fields := []*string{&pkg.Name, &pkg.Version}
+	for i := range pkg.Targets {
+		target := &pkg.Targets[i]
+		fields = append(fields, &target.Path, &target.StripPrefix, &target.Dest)
+		for j := range target.Exclude {
+			fields = append(fields, &target.Exclude[j])
+		}
+		for j := range target.Include {
+			fields = append(fields, &target.Include[j])
+		}
+	}
+	for i := range pkg.Dependencies {
+		dependency := &pkg.Dependencies[i]
+		fields = append(fields, &dependency.Name, &dependency.Version)
+	}
+
+	return m.expandFields(v, fields, pkg.Dependencies)
+}
+
+// expandUpdateConfig expands the variables in the packages and labels of an
+// update file.
+func (m *manifest) expandUpdateConfig(config *UpdateConfig, overrides map[string]string) error {
+	v := variables{overrides: overrides, vars: config.Vars}
+
+	fields := []*string{}
+	for i := range config.Updates {
+		dependency := &config.Updates[i]
+		fields = append(fields, &dependency.Name, &dependency.Version)
+	}
+	for i := range config.Labels {
+		fields = append(fields, &config.Labels[i])
+	}
+
+	return m.expandFields(v, fields, config.Updates)
+}
+
+// expandFields expands the variables in every field, then splits the
+// operator off the versions of dependencies that got it from a variable.
+func (m *manifest) expandFields(v variables, fields []*string, dependencies []Dependency) error {
+	for _, field := range fields {
+		expanded, err := v.expand(*field)
+		if err != nil {
+			needle := *field
+			if undefined, ok := err.(*undefinedVariableError); ok {
+				needle = "${" + undefined.name + "}"
+			}
+			return m.errorf(needle, 1, "%v", err)
+		}
+		*field = expanded
+	}
+
+	// A constraint may come entirely from a variable, e.g. "ver": "${MIN}"
+	for i := range dependencies {
+		dependency := &dependencies[i]
+		if dependency.Operator == "" {
+			dependency.Version, dependency.Operator = extractVersionAndOperator(dependency.Version)
+		}
+	}
+
+	return nil
+}
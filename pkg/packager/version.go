@@ -0,0 +1,120 @@
+package packager
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Special values of "ver" in package files, resolved when the file is read.
+const (
+	// versionGitDescribe takes the version from the latest tag reachable
+	// from HEAD of the repository the package file is in.
+	versionGitDescribe = "git-describe"
+	// versionFilePrefix reads the version from a file relative to the
+	// package file, e.g. "file:VERSION".
+	versionFilePrefix = "file:"
+	// versionEnvPrefix reads the version from an environment variable, e.g.
+	// "env:RELEASE_VERSION".
+	versionEnvPrefix = "env:"
+)
+
+// resolveVersion replaces a special "ver" value with the version it names,
+// normalised to major.minor.patch without a "v" prefix. Other values are
+// left as they are.
+func (m *manifest) resolveVersion(pkg *Package) error {
+	var version string
+	var err error
+
+	switch {
+	case pkg.Version == versionGitDescribe:
+		version, err = gitDescribeVersion(filepath.Dir(m.path))
+	case strings.HasPrefix(pkg.Version, versionFilePrefix):
+		version, err = fileVersion(filepath.Join(filepath.Dir(m.path), strings.TrimPrefix(pkg.Version, versionFilePrefix)))
+	case strings.HasPrefix(pkg.Version, versionEnvPrefix):
+		name := strings.TrimPrefix(pkg.Version, versionEnvPrefix)
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			err = fmt.Errorf("environment variable %s is not set", name)
+		}
+		version = strings.TrimSpace(value)
+	default:
+		return nil
+	}
+	if err != nil {
+		return m.errorf(strconv.Quote(pkg.Version), 1, "failed to resolve ver %q: %v", pkg.Version, err)
+	}
+	resolved, err := semver.NewVersion(version)
+	if err != nil {
+		return m.errorf(strconv.Quote(pkg.Version), 1, "ver %q resolved to %q, which is not a semantic version", pkg.Version, version)
+	}
+
+	// Every source gives the same form, e.g. a tag or file v1.2 gives 1.2.0
+	pkg.Version = resolved.String()
+	return nil
+}
+
+func fileVersion(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+var gitDescription = regexp.MustCompile(`^(.*)-([0-9]+)-g([0-9a-f]+)(-dirty)?$`)
+
+// gitDescribeVersion derives a version from `git describe` in dir. HEAD at a
+// tag v1.2.3 gives 1.2.3. Four commits after it gives 1.2.4-dev.4+g1a2b3c4,
+// a pre-release of the next patch version. Uncommitted changes add "dirty"
+// to the build metadata. Only the local repository is used.
+func gitDescribeVersion(dir string) (string, error) {
+	command := exec.Command("git", "describe", "--tags", "--long", "--dirty")
+	command.Dir = dir
+	output, err := command.Output()
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok && len(exitErr.Stderr) > 0 {
+			return "", fmt.Errorf("git describe: %s", strings.TrimSpace(string(exitErr.Stderr)))
+		}
+		return "", fmt.Errorf("git describe: %w", err)
+	}
+
+	description := strings.TrimSpace(string(output))
+	match := gitDescription.FindStringSubmatch(description)
+	if match == nil {
+		return "", fmt.Errorf("unexpected git describe output %q", description)
+	}
+	tag, commits, hash, dirty := match[1], match[2], match[3], match[4] != ""
+
+	tagVersion, err := semver.NewVersion(tag)
+	if err != nil {
+		return "", fmt.Errorf("tag %s is not a semantic version", tag)
+	}
+	version := *tagVersion
+
+	metadata := []string{}
+	if commits != "0" {
+		version, err = version.IncPatch().SetPrerelease("dev." + commits)
+		if err != nil {
+			return "", err
+		}
+		metadata = append(metadata, "g"+hash)
+	}
+	if dirty {
+		metadata = append(metadata, "dirty")
+	}
+	if len(metadata) > 0 {
+		version, err = version.SetMetadata(strings.Join(metadata, "."))
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return version.String(), nil
+}
@@ -0,0 +1,97 @@
+package packager
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSpecialVersions(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "VERSION"), []byte("3.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("GOPM_TEST_RELEASE", "v2.7.1")
+
+	tests := map[string]string{
+		"file:VERSION":          "3.1.0",
+		"env:GOPM_TEST_RELEASE": "2.7.1",
+		"1.0.0":                 "1.0.0",
+	}
+	for ver, want := range tests {
+		path := filepath.Join(dir, "packet.json")
+		content := `{"name": "p", "ver": "` + ver + `", "targets": ["x"]}`
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		pkg, err := readCreateFile(path, nil)
+		if err != nil {
+			t.Errorf("%s: %v", ver, err)
+			continue
+		}
+		if pkg.Version != want {
+			t.Errorf("%s: got version %q, want %q", ver, pkg.Version, want)
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "VERSION"), []byte("latest\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, "packet.json")
+	if err := os.WriteFile(path, []byte(`{"name": "p", "ver": "file:VERSION", "targets": ["x"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readCreateFile(path, nil)
+	if err == nil || !strings.Contains(err.Error(), "not a semantic version") {
+		t.Errorf("VERSION file with an invalid version: got error %v", err)
+	}
+}
+
+func TestGitDescribeVersion(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := t.TempDir()
+	git := func(args ...string) {
+		t.Helper()
+		command := exec.Command("git", append([]string{"-c", "user.name=gopm", "-c", "user.email=gopm@example.com"}, args...)...)
+		command.Dir = dir
+		if output, err := command.CombinedOutput(); err != nil {
+			t.Fatalf("git %s: %v\n%s", strings.Join(args, " "), err, output)
+		}
+	}
+	commit := func(name string) {
+		t.Helper()
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+		git("add", name)
+		git("commit", "-q", "-m", name)
+	}
+
+	git("init", "-q")
+	commit("a")
+	git("tag", "v1.2.3")
+
+	version, err := gitDescribeVersion(dir)
+	if err != nil || version != "1.2.3" {
+		t.Fatalf("at the tag: got %q, %v", version, err)
+	}
+
+	commit("b")
+	commit("c")
+	version, err = gitDescribeVersion(dir)
+	if err != nil || !strings.HasPrefix(version, "1.2.4-dev.2+g") {
+		t.Fatalf("two commits after the tag: got %q, %v", version, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, "c"), []byte("changed"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	version, err = gitDescribeVersion(dir)
+	if err != nil || !strings.HasSuffix(version, ".dirty") {
+		t.Fatalf("with uncommitted changes: got %q, %v", version, err)
+	}
+}